@@ -0,0 +1,162 @@
+// aggregate.go: Client-side aggregation of listens.
+
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"sort"
+	"strings"
+	"text/tabwriter"
+)
+
+// Group counts the listens sharing a key, such as an artist.
+type Group struct {
+	Label string
+	Count int
+}
+
+// normalizeName lowercases s, trims it and collapses its whitespace.
+func normalizeName(s string) string {
+	return strings.Join(strings.Fields(strings.ToLower(s)), " ")
+}
+
+// groupKey returns the grouping key of a name, normalized with -normalize.
+func groupKey(name string) string {
+	if normalize {
+		return normalizeName(name)
+	}
+	return name
+}
+
+// groupBy counts the listens by the name returned for each one, ordered
+// by descending count, leaving out groups with fewer than -min-count
+// listens. Groups are labelled with their most common name.
+func groupBy(listens []Listen, name func(Listen) string) []Group {
+	counts := make(map[string]int)
+	names := make(map[string]map[string]int)
+	for _, listen := range listens {
+		n := name(listen)
+		key := groupKey(n)
+		counts[key]++
+		if names[key] == nil {
+			names[key] = make(map[string]int)
+		}
+		names[key][n]++
+	}
+
+	groups := make([]Group, 0, len(counts))
+	for key, count := range counts {
+		if count < minCount {
+			continue
+		}
+		label := ""
+		for n, c := range names[key] {
+			if label == "" || c > names[key][label] || (c == names[key][label] && n < label) {
+				label = n
+			}
+		}
+		groups = append(groups, Group{Label: label, Count: count})
+	}
+
+	sort.Slice(groups, func(i, j int) bool {
+		if groups[i].Count != groups[j].Count {
+			return groups[i].Count > groups[j].Count
+		}
+		return groups[i].Label < groups[j].Label
+	})
+	return groups
+}
+
+// printGroups prints groups as a table of counts and labels.
+func printGroups(title string, groups []Group) {
+	w := tabwriter.NewWriter(output, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "LISTENS\t%s\n", title)
+	for _, group := range groups {
+		fmt.Fprintf(w, "%s\t%s\n", formatNumber(group.Count), group.Label)
+	}
+	w.Flush()
+}
+
+// printTopArtists prints the artists of the listens by descending count.
+func printTopArtists(listens []Listen) {
+	printGroups("ARTIST", groupBy(listens, func(listen Listen) string {
+		return listen.Track.Artist
+	}))
+}
+
+// printByArtist prints the listens under a header of each artist, artists
+// by descending count and the listens of each in their order.
+func printByArtist(listens []Listen) {
+	byArtist := make(map[string][]Listen)
+	for _, listen := range listens {
+		key := groupKey(listen.Track.Artist)
+		byArtist[key] = append(byArtist[key], listen)
+	}
+
+	groups := groupBy(listens, func(listen Listen) string {
+		return listen.Track.Artist
+	})
+	for _, group := range groups {
+		fmt.Fprintf(output, "%s (%s listens)\n", group.Label, formatNumber(group.Count))
+		for _, listen := range byArtist[groupKey(group.Label)] {
+			fmt.Fprintf(output, "    %s\n", formatListen(listen))
+		}
+	}
+}
+
+// UnknownSource labels the listens without submission source.
+const UnknownSource = "(unknown)"
+
+// printSources prints the submission sources of the listens, their
+// submission client or else the first source known, by descending count.
+func printSources(listens []Listen) {
+	printGroups("SOURCE", groupBy(listens, func(listen Listen) string {
+		if sources := listen.Track.Info.Sources(); len(sources) > 0 {
+			return sources[0]
+		}
+		return UnknownSource
+	}))
+}
+
+// KeepGroups are the groups -keep-last applies to.
+var KeepGroups = []string{"artist", "track"}
+
+// beyondLast returns, newest first, the listens of each artist, or of
+// each track with per "track", older than its newest n. Listens playing
+// now are never returned.
+func beyondLast(listens []Listen, n int, per string) []Listen {
+	sorted := timed(listens)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].ListenedAt > sorted[j].ListenedAt
+	})
+
+	seen := make(map[string]int)
+	var older []Listen
+	for _, listen := range sorted {
+		key := groupKey(listen.Track.Artist)
+		if per == "track" {
+			key += "\x00" + groupKey(listen.Track.Name)
+		}
+		if seen[key]++; seen[key] > n {
+			older = append(older, listen)
+		}
+	}
+	return older
+}
+
+// sample returns n of the listens picked at random from seed, in their
+// order, or all of them when there are no more than n.
+func sample(listens []Listen, n int, seed int64) []Listen {
+	if len(listens) <= n {
+		return listens
+	}
+	picked := rand.New(rand.NewSource(seed)).Perm(len(listens))[:n]
+	sort.Ints(picked)
+
+	sampled := make([]Listen, n)
+	for i, index := range picked {
+		sampled[i] = listens[index]
+	}
+	return sampled
+}
@@ -0,0 +1,96 @@
+// bandwidth.go: Limiting the transfer rate of responses.
+
+package main
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// BandwidthChunks is the number of reads a second of transfer is split
+// into, so that a limited body is read steadily rather than in bursts.
+const BandwidthChunks = 10
+
+// bandwidthLimiter spreads the reads of connections so that, all
+// together, they stay under -bandwidth bytes per second.
+type bandwidthLimiter struct {
+	mu   sync.Mutex
+	next time.Time
+}
+
+// bandwidth limits the connections of the run.
+var bandwidth bandwidthLimiter
+
+// Wait sleeps until n more bytes may have been read, taking their share
+// of the bandwidth.
+func (b *bandwidthLimiter) Wait(n int) {
+	b.mu.Lock()
+	now := time.Now()
+	if b.next.Before(now) {
+		b.next = now
+	}
+	b.next = b.next.Add(time.Duration(n) * time.Second / time.Duration(bandwidthMax))
+	wait := time.Until(b.next)
+	b.mu.Unlock()
+
+	if wait > 0 {
+		sleep(wait)
+	}
+}
+
+// limitedReader reads through the bandwidth limiter.
+type limitedReader struct {
+	r io.Reader
+}
+
+func (l limitedReader) Read(p []byte) (int, error) {
+	chunk := bandwidthMax / BandwidthChunks
+	if chunk < 1 {
+		chunk = 1
+	}
+	if int64(len(p)) > chunk {
+		p = p[:chunk]
+	}
+	n, err := l.r.Read(p)
+	if n > 0 {
+		bandwidth.Wait(n)
+	}
+	return n, err
+}
+
+// limitedConn is a connection read through the bandwidth limiter.
+type limitedConn struct {
+	net.Conn
+}
+
+func (c limitedConn) Read(p []byte) (int, error) {
+	return limitedReader{c.Conn}.Read(p)
+}
+
+// limitTransport returns a copy of transport, or of the default one when
+// it is not an *http.Transport, whose connections are read within
+// -bandwidth. The limit applies to the bytes on the wire, before TLS and
+// gzip decoding.
+func limitTransport(transport http.RoundTripper) http.RoundTripper {
+	t, ok := transport.(*http.Transport)
+	if !ok {
+		t = http.DefaultTransport.(*http.Transport)
+	}
+	t = t.Clone()
+	dial := t.DialContext
+	if dial == nil {
+		dial = (&net.Dialer{}).DialContext
+	}
+	t.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		conn, err := dial(ctx, network, addr)
+		if err != nil {
+			return nil, err
+		}
+		return limitedConn{conn}, nil
+	}
+	return t
+}
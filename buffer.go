@@ -0,0 +1,121 @@
+// buffer.go: Buffered output for streaming consumers.
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// openOutput redirects output to -output-file, truncated or, with
+// -append, appended to. The file is locked until the returned function
+// closes it, so that concurrent runs appending to it do not interleave.
+func openOutput() (func(), error) {
+	if outputFile == "" {
+		return func() {}, nil
+	}
+
+	flags := os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	if appendOutput {
+		flags = os.O_WRONLY | os.O_CREATE | os.O_APPEND
+	}
+	f, err := os.OpenFile(outputFile, flags, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	if appendOutput {
+		if err := lockFile(f); err != nil {
+			f.Close()
+			return nil, err
+		}
+	}
+
+	output = f
+	return func() {
+		output = os.Stdout
+		f.Close()
+	}, nil
+}
+
+// flushWriter buffers writes, flushing them after a number of lines and
+// on demand. It is safe for concurrent use.
+type flushWriter struct {
+	mu    sync.Mutex
+	w     *bufio.Writer
+	every int
+	lines int
+}
+
+func (fw *flushWriter) Write(p []byte) (int, error) {
+	fw.mu.Lock()
+	defer fw.mu.Unlock()
+
+	n, err := fw.w.Write(p)
+	if err != nil || fw.every <= 0 {
+		return n, err
+	}
+	if fw.lines += bytes.Count(p[:n], []byte{'\n'}); fw.lines >= fw.every {
+		fw.lines = 0
+		err = fw.w.Flush()
+	}
+	return n, err
+}
+
+// Flush writes out the buffered output.
+func (fw *flushWriter) Flush() error {
+	fw.mu.Lock()
+	defer fw.mu.Unlock()
+	fw.lines = 0
+	return fw.w.Flush()
+}
+
+// bufferOutput buffers output in -output-buffer bytes, flushed every
+// -flush-interval, after every -flush-every lines, and before exiting on
+// an interrupt or through exit. The returned function flushes the rest of
+// the output.
+func bufferOutput() func() {
+	if outputBuffer <= 0 {
+		return func() {}
+	}
+
+	fw := &flushWriter{w: bufio.NewWriterSize(output, outputBuffer), every: flushEvery}
+	output = io.Writer(fw)
+	atExit = append(atExit, func() { fw.Flush() })
+
+	done := make(chan struct{})
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+
+	go func() {
+		var tick <-chan time.Time
+		if flushInterval > 0 {
+			ticker := time.NewTicker(flushInterval)
+			defer ticker.Stop()
+			tick = ticker.C
+		}
+		for {
+			select {
+			case <-tick:
+				fw.Flush()
+			case sig := <-signals:
+				fw.Flush()
+				os.Exit(128 + int(sig.(syscall.Signal)))
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	return func() {
+		signal.Stop(signals)
+		close(done)
+		fw.Flush()
+		output = os.Stdout
+	}
+}
@@ -0,0 +1,67 @@
+// chunk.go: Listing in time-windowed chunks.
+
+package main
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+// listChunks lists the matched listens by chunks of -chunk, from the
+// newest one, each sorted oldest first, so that only a chunk is held in
+// memory. Each chunk spans from its newest listen, and the chunks without
+// listens are skipped; listens are not sorted across chunks.
+func listChunks(result *RunResult) {
+	defer startPager()()
+
+	w, flush := listWriter()
+	if tsvOutput {
+		fmt.Fprintln(w, tsvLine(listenColumns()))
+	} else if csvExtended {
+		fmt.Fprintln(w, csvLine(extendedColumns()))
+	}
+	var array *jsonArray
+	if jsonStream {
+		array = &jsonArray{w: w}
+		flush = func() { array.Close() }
+	}
+
+	var chunk []Listen
+	emit := func() {
+		sort.SliceStable(chunk, func(i, j int) bool {
+			return chunk[i].ListenedAt < chunk[j].ListenedAt
+		})
+		if downloadCover {
+			addCovers(chunk)
+		}
+		endProgress()
+		for _, listen := range chunk {
+			if array == nil {
+				fmt.Fprintln(w, formatListen(listen))
+			} else if err := array.Add(listen); err != nil {
+				fmt.Printf("Warning: failed encoding listen: %s: %s\n", listen, err)
+			}
+		}
+		chunk = chunk[:0]
+	}
+
+	var start time.Time
+	eachListen(getListens, newMatcher(), func(listen Listen) bool {
+		if !listen.NowPlaying() {
+			if !start.IsZero() && listen.Time().Before(start) {
+				debugf("chunk from %s: %d listens\n", start.In(location).Format(time.RFC3339), len(chunk))
+				emit()
+				start = time.Time{}
+			}
+			if start.IsZero() {
+				start = listen.Time().Add(-chunkSpan)
+			}
+		}
+		chunk = append(chunk, listen)
+		matched := result.Matched.Add(1)
+		return matchLimit <= 0 || matched < matchLimit
+	})
+	emit()
+	flush()
+}
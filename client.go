@@ -0,0 +1,413 @@
+// client.go: ListenBrainz API client.
+
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// TokenProvider returns the current API token. It is called before each
+// request, allowing tokens to be refreshed or read from secret managers.
+type TokenProvider func() (string, error)
+
+// StaticToken returns a TokenProvider of a fixed token.
+func StaticToken(token string) TokenProvider {
+	return func() (string, error) {
+		return token, nil
+	}
+}
+
+// Client performs authenticated requests to the ListenBrainz API.
+// Failed requests are retried while Retry has time left, and requests are
+// spread over the rate limit window by Pace.
+type Client struct {
+	API   string
+	Token TokenProvider
+	HTTP  *http.Client
+	Retry *RetryBudget
+	Pace  *Pacer
+}
+
+// TokenValidation is the response of the validate-token endpoint.
+type TokenValidation struct {
+	Code     int    `json:"code"`
+	Message  string `json:"message"`
+	Valid    bool   `json:"valid"`
+	UserName string `json:"user_name"`
+}
+
+// client is the Client used by the command.
+var client *Client
+
+// runContext bounds the requests of the whole run with -timeout.
+var runContext = context.Background()
+
+// sleep waits for d, returning early when runContext is done, so that
+// waiting on rate limits and retries does not outlast -timeout.
+func sleep(d time.Duration) {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+	case <-runContext.Done():
+	}
+}
+
+// NewClient returns a Client for the public ListenBrainz API.
+func NewClient(token TokenProvider) *Client {
+	return &Client{
+		API:   ListenBrainzAPI,
+		Token: token,
+		HTTP:  &http.Client{},
+	}
+}
+
+// insecureTransport returns an HTTP transport that does not verify TLS
+// certificates, for instances with self-signed ones.
+func insecureTransport() http.RoundTripper {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
+	return transport
+}
+
+// do sends an authenticated request, retrying it within the retry budget,
+// and throttles on the rate limit. The token is registered as a secret,
+// so that it is redacted from the errors returned and from debug logs;
+// the Authorization header itself is never logged.
+func (c *Client) do(req *http.Request) (*http.Response, error) {
+	for attempt := 0; ; attempt++ {
+		token, err := c.Token()
+		if err != nil {
+			return nil, redactedError{fmt.Errorf("failed getting token: %w", err)}
+		}
+		addSecret(token)
+		req.Header.Set("Authorization", fmt.Sprintf("Token %s", token))
+
+		if attempt == 0 {
+			printCurl(req)
+		}
+		c.Pace.Wait()
+		resp, err := c.HTTP.Do(req)
+		if resp != nil {
+			c.Pace.Observe(resp)
+		}
+		if !retryable(resp, err) {
+			if err != nil {
+				return nil, redactedError{err}
+			}
+			throttle(resp)
+			return resp, nil
+		}
+
+		wait := retryDelay(resp, attempt)
+		if !c.Retry.Spend(wait) {
+			if err != nil {
+				return nil, redactedError{err}
+			}
+			throttle(resp)
+			return resp, nil
+		}
+		if resp != nil {
+			resp.Body.Close()
+		}
+
+		reason := fmt.Sprint(err)
+		if err == nil {
+			reason = resp.Status
+		}
+		debugf("%s %s: %s, retrying in %s (%s of retry budget left)\n",
+			req.Method, req.URL.Path, reason, wait, c.Retry.Remaining().Round(time.Second))
+		sleep(wait)
+
+		if req.GetBody != nil {
+			if req.Body, err = req.GetBody(); err != nil {
+				return nil, redactedError{err}
+			}
+		}
+	}
+}
+
+// readBody reads the body of a response, up to -max-body-size bytes once
+// decompressed. The transport requests gzip and decompresses it
+// transparently, as long as Accept-Encoding is not set by hand; bodies
+// still gzip-encoded are decompressed here.
+func readBody(resp *http.Response) ([]byte, error) {
+	var body io.Reader = resp.Body
+	if !resp.Uncompressed && resp.Header.Get("Content-Encoding") == "gzip" {
+		gz, err := gzip.NewReader(resp.Body)
+		if err != nil {
+			return nil, err
+		}
+		defer gz.Close()
+		body = gz
+	}
+
+	debugf("%s %s: compressed: %t\n",
+		resp.Request.Method, resp.Request.URL.Path, resp.Uncompressed || body != resp.Body)
+
+	if maxBodySize <= 0 {
+		return io.ReadAll(body)
+	}
+
+	data, err := io.ReadAll(io.LimitReader(body, maxBodySize+1))
+	if err == nil && int64(len(data)) > maxBodySize {
+		err = fmt.Errorf("response body larger than -max-body-size %d bytes", maxBodySize)
+	}
+	return data, err
+}
+
+// decodeJSON decodes a response body, failing on unknown fields with
+// -strict to catch changes of the API.
+func decodeJSON(body []byte, v interface{}) error {
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	if strictJSON {
+		decoder.DisallowUnknownFields()
+	}
+	return decoder.Decode(v)
+}
+
+// ValidateToken returns the validity and user of the token.
+func (c *Client) ValidateToken() (TokenValidation, error) {
+	var validation TokenValidation
+
+	req, err := http.NewRequestWithContext(runContext, "GET", c.API+"/validate-token", nil)
+	if err != nil {
+		return validation, err
+	}
+
+	resp, err := c.do(req)
+	if err != nil {
+		return validation, err
+	}
+	defer resp.Body.Close()
+
+	body, err := readBody(resp)
+	if err != nil {
+		return validation, err
+	}
+
+	if err := decodeJSON(body, &validation); err != nil {
+		return validation, fmt.Errorf("%s: %w", resp.Status, err)
+	}
+
+	debugf("validateToken(): response status: %s\n", resp.Status)
+
+	return validation, nil
+}
+
+// checkToken validates the token and exits when it is invalid.
+func checkToken() TokenValidation {
+	validation, err := client.ValidateToken()
+	if err != nil {
+		fail("failed validating token", err)
+	}
+	if !validation.Valid {
+		fmt.Printf("Error: invalid %s: %s\n", tokenEnv, validation.Message)
+		exit(1)
+	}
+	return validation
+}
+
+// checkDeleteUser exits when the token cannot delete the listens of user.
+func checkDeleteUser(validation TokenValidation, user string) {
+	if !strings.EqualFold(validation.UserName, user) {
+		fmt.Printf("Error: token belongs to user %s, cannot delete listens of user %s.\n",
+			validation.UserName, user)
+		exit(1)
+	}
+}
+
+// DeleteListen deletes a listen of the token's user.
+func (c *Client) DeleteListen(listen Listen) error {
+	url := c.API + "/delete-listen"
+
+	// Create a payload to send in the request
+	payload := map[string]string{
+		"listened_at":    fmt.Sprintf("%d", listen.ListenedAt),
+		"recording_msid": listen.Recording,
+	}
+
+	jsonpayload, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	// Create a new http post request
+	req, err := http.NewRequestWithContext(runContext, "POST", url, bytes.NewBuffer(jsonpayload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	// Make the request
+	resp, err := c.do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	debugf("deletelisten(%s, %s): response status: %s\n",
+		listen.Time(), listen.Recording, resp.Status)
+
+	if resp.StatusCode != http.StatusOK {
+		return statusError(resp)
+	}
+	return nil
+}
+
+// ListenCount is the response of the listen-count endpoint.
+type ListenCount struct {
+	Payload struct {
+		Count int64 `json:"count"`
+	} `json:"payload"`
+}
+
+// GetListenCount returns the total number of listens of user.
+func (c *Client) GetListenCount(user string) (int64, error) {
+	req, err := http.NewRequestWithContext(runContext, "GET", fmt.Sprintf("%s/user/%s/listen-count", c.API, user), nil)
+	if err != nil {
+		return 0, err
+	}
+
+	resp, err := c.do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	body, err := readBody(resp)
+	if err != nil {
+		return 0, err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return 0, statusError(resp)
+	}
+
+	var count ListenCount
+	if err := decodeJSON(body, &count); err != nil {
+		return 0, fmt.Errorf("%s: %w", resp.Status, err)
+	}
+
+	return count.Payload.Count, nil
+}
+
+// GetListens returns a page of listens of user older than max, or the
+// latest ones when max is zero. With -retry-on-empty-page, empty pages
+// are fetched again before being taken as the end of the listens, since
+// a flaky empty response would otherwise truncate paging.
+func (c *Client) GetListens(user string, max int64) Listens {
+	url := fmt.Sprintf("%s/user/%s/listens?count=%d",
+		c.API, user, ItemsPerPage)
+
+	if max > 0 {
+		url = fmt.Sprintf("%s&max_ts=%d", url, max)
+	}
+
+	listens, err := c.fetchListens(url)
+	for retry := 0; err == nil && listens.length() == 0 && retry < emptyRetries; retry++ {
+		wait := retryDelay(nil, retry)
+		if !c.Retry.Spend(wait) {
+			break
+		}
+		debugf("empty page of listens before %d, retrying in %s (%d of %d)\n", max, wait, retry+1, emptyRetries)
+		sleep(wait)
+		if listens, err = c.fetchListens(url); err == nil && listens.length() > 0 {
+			fmt.Printf("Warning: page of listens before %d was empty, then had %d listens.\n", max, listens.length())
+		}
+	}
+	return checkListens(listens, err)
+}
+
+// GetListensAfter returns a page of listens of user newer than min.
+func (c *Client) GetListensAfter(user string, min int64) Listens {
+	return c.getListens(fmt.Sprintf("%s/user/%s/listens?count=%d&min_ts=%d",
+		c.API, user, ItemsPerPage, min))
+}
+
+// GetListensBetween returns a page of listens of user newer than min and
+// older than max.
+func (c *Client) GetListensBetween(user string, min, max int64) Listens {
+	return c.getListens(fmt.Sprintf("%s/user/%s/listens?count=%d&min_ts=%d&max_ts=%d",
+		c.API, user, ItemsPerPage, min, max))
+}
+
+// GetPlayingNow returns the listen user is playing now, if any.
+func (c *Client) GetPlayingNow(user string) Listens {
+	return c.getListens(fmt.Sprintf("%s/user/%s/playing-now", c.API, user))
+}
+
+func (c *Client) getListens(url string) Listens {
+	return checkListens(c.fetchListens(url))
+}
+
+// checkListens returns a fetched page of listens, failing with the exit
+// code of the error otherwise, since an empty page would end paging as if
+// the listens were all fetched.
+func checkListens(listens Listens, err error) Listens {
+	if err != nil {
+		fail("failed getting listens", err)
+	}
+	return listens
+}
+
+// fetchListens fetches a page of listens, retrying it within the retry
+// budget when it takes longer than -page-timeout.
+func (c *Client) fetchListens(url string) (Listens, error) {
+	for {
+		listens, err := c.fetchPage(url)
+		if !errors.Is(err, context.DeadlineExceeded) || runContext.Err() != nil ||
+			!c.Retry.Spend(pageTimeout) {
+			return listens, err
+		}
+		debugf("page timed out after %s, retrying (%s of retry budget left)\n",
+			pageTimeout, c.Retry.Remaining().Round(time.Second))
+	}
+}
+
+func (c *Client) fetchPage(url string) (Listens, error) {
+	var listens Listens
+
+	ctx := runContext
+	if pageTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(runContext, pageTimeout)
+		defer cancel()
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return listens, fmt.Errorf("creating request: %w", err)
+	}
+
+	resp, err := c.do(req)
+	if err != nil {
+		return listens, fmt.Errorf("sending request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := readBody(resp)
+	if err != nil {
+		return listens, fmt.Errorf("reading response body: %w", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return listens, statusError(resp)
+	}
+
+	if err := decodeJSON(body, &listens); err != nil {
+		return listens, err
+	}
+
+	return listens, nil
+}
@@ -0,0 +1,69 @@
+// compare.go: Listening overlap between users.
+
+package main
+
+import (
+	"fmt"
+	"sort"
+)
+
+// listensOf returns the matched listens of user.
+func listensOf(user string) []Listen {
+	defer func(user string) { userName = user }(userName)
+	userName = user
+	return getMatchedListens()
+}
+
+// overlap returns the keys present in both counts, by descending combined
+// count, and the number of distinct keys in either.
+func overlap(a, b map[string]int) ([]string, int) {
+	var shared []string
+	union := len(b)
+	for key := range a {
+		if _, ok := b[key]; ok {
+			shared = append(shared, key)
+		} else {
+			union++
+		}
+	}
+	sort.Slice(shared, func(i, j int) bool {
+		ci, cj := a[shared[i]]+b[shared[i]], a[shared[j]]+b[shared[j]]
+		if ci != cj {
+			return ci > cj
+		}
+		return shared[i] < shared[j]
+	})
+	return shared, union
+}
+
+func percent(n, total int) float64 {
+	if total == 0 {
+		return 0
+	}
+	return 100 * float64(n) / float64(total)
+}
+
+// compareUsers prints the artists and tracks both -u and other listened to.
+func compareUsers(other string) {
+	artists := []map[string]int{{}, {}}
+	tracks := []map[string]int{{}, {}}
+	for i, user := range []string{userName, other} {
+		for _, listen := range listensOf(user) {
+			artists[i][groupKey(listen.Track.Artist)]++
+			tracks[i][groupKey(listen.Track.Artist)+" - \""+groupKey(listen.Track.Name)+"\""]++
+		}
+	}
+
+	sharedArtists, allArtists := overlap(artists[0], artists[1])
+	sharedTracks, allTracks := overlap(tracks[0], tracks[1])
+
+	fmt.Fprintf(output, "Shared artists: %s of %s (%.1f%%)\n", formatNumber(len(sharedArtists)),
+		formatNumber(allArtists), percent(len(sharedArtists), allArtists))
+	fmt.Fprintf(output, "Shared tracks: %s of %s (%.1f%%)\n", formatNumber(len(sharedTracks)),
+		formatNumber(allTracks), percent(len(sharedTracks), allTracks))
+
+	for _, artist := range sharedArtists {
+		fmt.Fprintf(output, "    %s (%s, %s)\n", artist,
+			formatNumber(artists[0][artist]), formatNumber(artists[1][artist]))
+	}
+}
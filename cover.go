@@ -0,0 +1,99 @@
+// cover.go: Cover art from the Cover Art Archive.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+// CoverArtArchive points to the root of the Cover Art Archive API.
+// https://musicbrainz.org/doc/Cover_Art_Archive/API
+const CoverArtArchive = "https://coverartarchive.org"
+
+// CoverImages is the response of the release endpoint of the Cover Art
+// Archive.
+type CoverImages struct {
+	Images []struct {
+		Front bool   `json:"front"`
+		Image string `json:"image"`
+	} `json:"images"`
+}
+
+// covers caches the front cover URL of each release MBID, empty for the
+// releases without one.
+var covers = make(map[string]string)
+
+// GetFrontCover returns the URL of the front cover of a release, or an
+// empty string when it has none. The Cover Art Archive is queried without
+// the API token.
+func (c *Client) GetFrontCover(release string) (string, error) {
+	req, err := http.NewRequestWithContext(runContext, "GET", CoverArtArchive+"/release/"+release, nil)
+	if err != nil {
+		return "", err
+	}
+
+	resp, err := c.HTTP.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return "", nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", statusError(resp)
+	}
+
+	body, err := readBody(resp)
+	if err != nil {
+		return "", err
+	}
+
+	var images CoverImages
+	if err := json.Unmarshal(body, &images); err != nil {
+		return "", fmt.Errorf("%s: %w", resp.Status, err)
+	}
+	for _, image := range images.Images {
+		if image.Front {
+			return image.Image, nil
+		}
+	}
+	return "", nil
+}
+
+// coverOf returns the front cover URL of the release of a listen, from
+// its mapping when it names the cover, else from the Cover Art Archive.
+func coverOf(listen Listen) string {
+	mapping := listen.Track.Mapping
+	if mapping == nil {
+		return ""
+	}
+	if mapping.CAAReleaseMBID != "" && mapping.CAAID != 0 {
+		return fmt.Sprintf("%s/release/%s/%d.jpg", CoverArtArchive, mapping.CAAReleaseMBID, mapping.CAAID)
+	}
+	if mapping.ReleaseMBID == "" {
+		return ""
+	}
+
+	url, ok := covers[mapping.ReleaseMBID]
+	if !ok {
+		var err error
+		if url, err = client.GetFrontCover(mapping.ReleaseMBID); err != nil {
+			fmt.Printf("Warning: failed getting cover of release %s: %s\n", mapping.ReleaseMBID, err)
+		} else if url == "" {
+			debugf("no cover for release %s\n", mapping.ReleaseMBID)
+		}
+		covers[mapping.ReleaseMBID] = url
+	}
+	return url
+}
+
+// addCovers sets the cover URL of the listens with -download-cover.
+func addCovers(listens []Listen) {
+	for i := range listens {
+		listens[i].CoverArt = coverOf(listens[i])
+	}
+}
@@ -0,0 +1,128 @@
+// dedup.go: Near-duplicate listens.
+
+package main
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+// Keys of -dedupe-on identifying duplicate listens.
+const (
+	// DedupListen identifies a listen by its time and recording_msid.
+	DedupListen = "listen"
+	// DedupTrack identifies a listen by its artist and track.
+	DedupTrack = "track"
+	// DedupTrackWindow identifies a listen by its artist and track, for
+	// listens within -dedup-window of each other.
+	DedupTrackWindow = "track-window"
+)
+
+// DedupKeys are the keys selectable with -dedupe-on.
+var DedupKeys = []string{DedupListen, DedupTrack, DedupTrackWindow}
+
+// dedupKey returns the key identifying duplicates of a listen under on.
+func dedupKey(listen Listen, on string) string {
+	if on == DedupListen {
+		return fmt.Sprintf("%d\x00%s", listen.ListenedAt, listen.Recording)
+	}
+	return listen.Track.Artist + "\x00" + listen.Track.Name
+}
+
+// newDeduper returns a function reporting whether a listen duplicates
+// one it was given before, under the key on. Listens are expected in
+// time order, either way.
+func newDeduper(on string) func(Listen) bool {
+	last := make(map[string]time.Time)
+	return func(listen Listen) bool {
+		key := dedupKey(listen, on)
+		prev, seen := last[key]
+		last[key] = listen.Time()
+		if on == DedupTrackWindow {
+			gap := listen.Time().Sub(prev)
+			return seen && gap <= dedupWindow && -gap <= dedupWindow
+		}
+		return seen
+	}
+}
+
+// duplicateGroups groups the listens sharing a key under on, and within
+// window of the previous one for DedupTrackWindow, returning the groups
+// of two or more listens ordered by time.
+func duplicateGroups(listens []Listen, on string, window time.Duration) [][]Listen {
+	sorted := timed(listens)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].ListenedAt < sorted[j].ListenedAt
+	})
+
+	var groups [][]Listen
+	current := make(map[string]int)
+	for _, listen := range sorted {
+		key := dedupKey(listen, on)
+		if i, ok := current[key]; ok {
+			group := groups[i]
+			if on != DedupTrackWindow || listen.Time().Sub(group[len(group)-1].Time()) <= window {
+				groups[i] = append(group, listen)
+				continue
+			}
+		}
+		current[key] = len(groups)
+		groups = append(groups, []Listen{listen})
+	}
+
+	var duplicates [][]Listen
+	for _, group := range groups {
+		if len(group) > 1 {
+			duplicates = append(duplicates, group)
+		}
+	}
+	return duplicates
+}
+
+// printDuplicates prints the groups of duplicate listens under -dedupe-on,
+// near-duplicates of the same track by default.
+func printDuplicates(listens []Listen) {
+	on := dedupeOn
+	if on == "" {
+		on = DedupTrackWindow
+	}
+	for _, group := range duplicateGroups(listens, on, dedupWindow) {
+		fmt.Fprintf(output, "%s - \"%s\" (%d listens)\n",
+			group[0].Track.Artist, group[0].Track.Name, len(group))
+		for _, listen := range group {
+			fmt.Fprintf(output, "    %s <%s>\n",
+				formatTime(listen), listen.Recording)
+		}
+	}
+}
+
+// mergeAdjacent returns the listens, in their order, with the listens of
+// the same artist and track played back to back, each within window of
+// the one before, merged into the first of them.
+func mergeAdjacent(listens []Listen, window time.Duration) []Listen {
+	order := make([]int, len(listens))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(i, j int) bool {
+		return listens[order[i]].ListenedAt < listens[order[j]].ListenedAt
+	})
+
+	merged := make([]bool, len(listens))
+	for i := 1; i < len(order); i++ {
+		prev, listen := listens[order[i-1]], listens[order[i]]
+		if !prev.NowPlaying() && dedupKey(prev, DedupTrack) == dedupKey(listen, DedupTrack) &&
+			listen.Time().Sub(prev.Time()) <= window {
+			merged[order[i]] = true
+		}
+	}
+
+	var kept []Listen
+	for i, listen := range listens {
+		if !merged[i] {
+			kept = append(kept, listen)
+		}
+	}
+	return kept
+}
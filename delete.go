@@ -0,0 +1,78 @@
+// delete.go: Concurrent deletion of listens.
+
+package main
+
+import (
+	"fmt"
+	"sync"
+)
+
+// deleter deletes listens with -delete-concurrency workers, counting the
+// deleted and failed ones in a RunResult. With -fail-fast, it stops
+// deleting after the first failure.
+type deleter struct {
+	result  *RunResult
+	listens chan Listen
+	wg      sync.WaitGroup
+
+	mu     sync.Mutex
+	failed Listen
+	err    error
+}
+
+// newDeleter starts the workers of a deleter.
+func newDeleter(result *RunResult) *deleter {
+	d := &deleter{result: result, listens: make(chan Listen)}
+	for i := 0; i < deleteWorkers; i++ {
+		d.wg.Add(1)
+		go d.work()
+	}
+	return d
+}
+
+func (d *deleter) work() {
+	defer d.wg.Done()
+	for listen := range d.listens {
+		if d.stopped() {
+			continue
+		}
+		if err := client.DeleteListen(listen); err != nil {
+			d.result.Failed.Add(1)
+			if failFast {
+				d.mu.Lock()
+				if d.err == nil {
+					d.failed, d.err = listen, err
+				}
+				d.mu.Unlock()
+				continue
+			}
+			fmt.Printf("Warning: failed deleting listen: %s: %s\n", listen, err)
+		} else {
+			d.result.Deleted.Add(1)
+		}
+	}
+}
+
+func (d *deleter) stopped() bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.err != nil
+}
+
+// Delete queues a listen for deletion, reporting false when deletions
+// stopped on a failure.
+func (d *deleter) Delete(listen Listen) bool {
+	if d.stopped() {
+		return false
+	}
+	d.listens <- listen
+	return true
+}
+
+// Wait waits for the queued deletions to end, returning the listen that
+// stopped them with -fail-fast and its error, if any.
+func (d *deleter) Wait() (Listen, error) {
+	close(d.listens)
+	d.wg.Wait()
+	return d.failed, d.err
+}
@@ -0,0 +1,95 @@
+// errors.go: Errors of the ListenBrainz API.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// Errors of API responses, matched by errors.Is against the errors the
+// Client methods return.
+var (
+	ErrUnauthorized = errors.New("unauthorized")
+	ErrUserNotFound = errors.New("user not found")
+	ErrRateLimited  = errors.New("rate limited")
+	ErrServer       = errors.New("server error")
+)
+
+// StatusError is an API response of an unexpected status. It is one of
+// the Err* errors by its status, and tells when to retry rate limited
+// requests.
+type StatusError struct {
+	StatusCode int
+	Status     string
+	RetryAfter time.Duration
+}
+
+// statusError returns the StatusError of a response.
+func statusError(resp *http.Response) *StatusError {
+	err := &StatusError{StatusCode: resp.StatusCode, Status: resp.Status}
+	if resp.StatusCode == http.StatusTooManyRequests {
+		err.RetryAfter = retryDelay(resp, 0)
+	}
+	return err
+}
+
+func (e *StatusError) Error() string {
+	return "response status: " + e.Status
+}
+
+// Is reports whether the status is the one of target.
+func (e *StatusError) Is(target error) bool {
+	switch target {
+	case ErrUnauthorized:
+		return e.StatusCode == http.StatusUnauthorized || e.StatusCode == http.StatusForbidden
+	case ErrUserNotFound:
+		return e.StatusCode == http.StatusNotFound
+	case ErrRateLimited:
+		return e.StatusCode == http.StatusTooManyRequests
+	case ErrServer:
+		return e.StatusCode >= 500
+	}
+	return false
+}
+
+// Exit codes of the command for API errors, other failures exiting with 1.
+const (
+	ExitUnauthorized = 3
+	ExitUserNotFound = 4
+	ExitRateLimited  = 5
+	ExitServer       = 6
+)
+
+// apiErrors maps the API errors to their exit code and hint.
+var apiErrors = []struct {
+	err  error
+	code int
+	hint string
+}{
+	{ErrUnauthorized, ExitUnauthorized, "check the API token and the user it belongs to"},
+	{ErrUserNotFound, ExitUserNotFound, "check the user name"},
+	{ErrRateLimited, ExitRateLimited, "retry later or raise -retry-budget"},
+	{ErrServer, ExitServer, "ListenBrainz may be down, retry later"},
+}
+
+// fail prints an error, with a hint for API errors, and exits with its
+// exit code, or 1 when it is not an API error.
+func fail(message string, err error) {
+	for _, apiErr := range apiErrors {
+		if !errors.Is(err, apiErr.err) {
+			continue
+		}
+		hint := apiErr.hint
+		var status *StatusError
+		if errors.As(err, &status) && status.RetryAfter > 0 {
+			hint = fmt.Sprintf("%s, in %s", hint, status.RetryAfter)
+		}
+		fmt.Printf("Error: %s: %s (%s).\n", message, err, hint)
+		exit(apiErr.code)
+	}
+	fmt.Printf("Error: %s: %s\n", message, err)
+	exit(1)
+}
@@ -6,5 +6,6 @@ require (
 	github.com/jstemmer/gotags v1.4.1 // indirect
 	golang.org/x/mod v0.10.0 // indirect
 	golang.org/x/sys v0.7.0 // indirect
+	golang.org/x/text v0.14.0
 	golang.org/x/tools v0.8.0 // indirect
 )
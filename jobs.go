@@ -0,0 +1,209 @@
+// jobs.go: Batch of queries.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// Job actions.
+const (
+	ActionList       = "list"
+	ActionDelete     = "delete"
+	ActionCountByDay = "count-by-day"
+)
+
+// Job describes a named query of a jobs file. Empty fields default to
+// the command line flags.
+type Job struct {
+	Name    string `json:"name"`
+	User    string `json:"user"`
+	Pattern string `json:"pattern"`
+	Album   string `json:"album"`
+	Since   string `json:"since"`
+	Action  string `json:"action"`
+}
+
+// user returns the user of the job, defaulting to -u.
+func (job Job) user() string {
+	if job.User != "" {
+		return job.User
+	}
+	return userName
+}
+
+// deletes reports whether the job deletes listens, defaulting to -d.
+func (job Job) deletes() bool {
+	return (job.Action == ActionDelete && !safeMode()) || (job.Action == "" && deleteListens)
+}
+
+func readJobs(path string) ([]Job, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var jobs []Job
+	if err := json.Unmarshal(data, &jobs); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	for i, job := range jobs {
+		if job.Name == "" {
+			jobs[i].Name = fmt.Sprintf("#%d", i+1)
+		}
+		if job.User == "" && userName == "" {
+			return nil, fmt.Errorf("job %s: user is missing", jobs[i].Name)
+		}
+		switch job.Action {
+		case "", ActionList, ActionDelete, ActionCountByDay:
+		default:
+			return nil, fmt.Errorf("job %s: invalid action: %s", jobs[i].Name, job.Action)
+		}
+		if job.Since != "" {
+			if _, err := parseSpan(job.Since); err != nil {
+				return nil, fmt.Errorf("job %s: %w", jobs[i].Name, err)
+			}
+		}
+	}
+
+	return jobs, nil
+}
+
+// prefetched are the listens of each user fetched by prefetchUsers,
+// served instead of fetching while running the jobs.
+var prefetched map[string][]Listen
+
+// cutOff returns the time from which the job lists listens, or zero for
+// all of them.
+func (job Job) cutOff() time.Time {
+	if job.Since != "" {
+		since, _ := parseSpan(job.Since)
+		return time.Now().Add(-since)
+	}
+	return cutOffTime
+}
+
+// prefetchUsers fetches the listens the jobs of each user need, the
+// users' listens being fetched -concurrent-users at once. Pages are
+// requested through the shared client, so they are paced and throttled
+// across all users.
+func prefetchUsers(jobs []Job) {
+	var users []string
+	cutOffs := make(map[string]time.Time)
+	for _, job := range jobs {
+		cutOff, seen := cutOffs[job.user()]
+		if !seen {
+			users = append(users, job.user())
+		}
+		if !seen || job.cutOff().IsZero() || (!cutOff.IsZero() && job.cutOff().Before(cutOff)) {
+			cutOffs[job.user()] = job.cutOff()
+		}
+	}
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	workers := make(chan struct{}, userWorkers)
+	prefetched = make(map[string][]Listen)
+	for _, user := range users {
+		wg.Add(1)
+		workers <- struct{}{}
+		go func(user string) {
+			defer wg.Done()
+			listens := fetchUser(user, cutOffs[user])
+			debugf("prefetched %d listens of %s\n", len(listens), user)
+			mu.Lock()
+			prefetched[user] = listens
+			mu.Unlock()
+			<-workers
+		}(user)
+	}
+	wg.Wait()
+}
+
+// fetchUser returns the listens of user from cutOff, or all of them when
+// zero, newest first. The -dump-state snapshot records them once served
+// by getListens.
+func fetchUser(user string, cutOff time.Time) []Listen {
+	var listens []Listen
+	walkListens(func(max int64) Listens {
+		sleepJitter()
+		return client.GetListens(user, max)
+	}, cutOff, func(listen Listen) bool {
+		listens = append(listens, listen)
+		return true
+	})
+	return listens
+}
+
+// runJob sets the flags of a job, runs it and restores the flags.
+func runJob(job Job) *RunResult {
+	defer func(user, search, album string, cutOff time.Time, del, days bool) {
+		userName, searchPattern, albumPattern = user, search, album
+		cutOffTime, deleteListens, dayCounts = cutOff, del, days
+	}(userName, searchPattern, albumPattern, cutOffTime, deleteListens, dayCounts)
+
+	if job.User != "" {
+		userName = job.User
+	}
+	if job.Pattern != "" {
+		searchPattern = job.Pattern
+	}
+	if job.Album != "" {
+		albumPattern = job.Album
+	}
+	cutOffTime = job.cutOff()
+	switch job.Action {
+	case ActionList:
+		deleteListens, dayCounts = false, false
+	case ActionDelete:
+		deleteListens, dayCounts = !safeMode(), false
+	case ActionCountByDay:
+		deleteListens, dayCounts = false, true
+	}
+
+	return brainz()
+}
+
+// runJobs runs the jobs of a jobs file in sequence, returning their
+// total result. With -concurrent-users, the listens of the users are
+// fetched first, so that the output of the jobs is not interleaved.
+func runJobs(path string) *RunResult {
+	jobs, err := readJobs(path)
+	if err != nil {
+		fmt.Println("Error:", err)
+		exit(1)
+	}
+
+	var validation TokenValidation
+	for _, job := range jobs {
+		if !job.deletes() {
+			continue
+		}
+		if !validation.Valid {
+			validation = checkToken()
+		}
+		checkDeleteUser(validation, job.user())
+	}
+
+	if userWorkers > 1 {
+		prefetchUsers(jobs)
+	}
+
+	total := &RunResult{}
+	for _, job := range jobs {
+		result := runJob(job)
+		total.Add(result)
+		counts := result.Counts()
+		if quietNoop && counts.Matched == 0 {
+			continue
+		}
+		fmt.Fprintf(output, "Job %s: %s matched, %s deleted, %s failed.\n", job.Name,
+			formatNumber(counts.Matched), formatNumber(counts.Deleted), formatNumber(counts.Failed))
+	}
+	return total
+}
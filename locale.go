@@ -0,0 +1,55 @@
+// locale.go: Number formatting of summaries.
+
+package main
+
+import (
+	"os"
+	"strconv"
+	"strings"
+)
+
+// thousandsSeparators maps language codes to their thousands separator,
+// defaulting to a comma.
+var thousandsSeparators = map[string]string{
+	"da": ".", "de": ".", "es": ".", "id": ".", "it": ".", "nl": ".", "pt": ".", "tr": ".",
+	"cs": " ", "fi": " ", "fr": " ", "nb": " ", "pl": " ", "ru": " ", "sv": " ", "uk": " ",
+}
+
+// thousandsSeparator returns the separator of -locale or of the system
+// locale environment.
+func thousandsSeparator() string {
+	locale := numberLocale
+	for _, env := range []string{"LC_ALL", "LC_NUMERIC", "LANG"} {
+		if locale != "" {
+			break
+		}
+		locale = os.Getenv(env)
+	}
+	lang := strings.ToLower(locale)
+	if i := strings.IndexAny(lang, "_-.@"); i >= 0 {
+		lang = lang[:i]
+	}
+	if sep, ok := thousandsSeparators[lang]; ok {
+		return sep
+	}
+	return ","
+}
+
+// formatNumber formats n with thousands separators.
+func formatNumber[T int | int64](n T) string {
+	digits := strconv.FormatInt(int64(n), 10)
+	sign := ""
+	if n < 0 {
+		sign, digits = "-", digits[1:]
+	}
+
+	sep := thousandsSeparator()
+	var b strings.Builder
+	for i, d := range digits {
+		if i > 0 && (len(digits)-i)%3 == 0 {
+			b.WriteString(sep)
+		}
+		b.WriteRune(d)
+	}
+	return sign + b.String()
+}
@@ -0,0 +1,14 @@
+//go:build !unix
+
+// lock_other.go: Advisory file locking, unsupported.
+
+package main
+
+import (
+	"os"
+)
+
+// lockFile does nothing where advisory locks are not supported.
+func lockFile(f *os.File) error {
+	return nil
+}
@@ -0,0 +1,16 @@
+//go:build unix
+
+// lock_unix.go: Advisory file locking.
+
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+// lockFile takes an exclusive advisory lock on f, waiting for other
+// holders to release it. The lock is released when f is closed.
+func lockFile(f *os.File) error {
+	return syscall.Flock(int(f.Fd()), syscall.LOCK_EX)
+}
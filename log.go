@@ -0,0 +1,121 @@
+// log.go: Debug logging with secrets redacted.
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Redacted replaces secrets in logged messages.
+const Redacted = "***"
+
+// logOutput receives the messages kept out of the data output, stderr
+// unless -log-stdout is given.
+var logOutput io.Writer = os.Stderr
+
+var (
+	secretsMu sync.Mutex
+	// secrets are the values, such as API tokens, never to be logged.
+	secrets = make(map[string]bool)
+)
+
+// addSecret registers a value to redact from logged messages.
+func addSecret(secret string) {
+	if secret == "" {
+		return
+	}
+	secretsMu.Lock()
+	defer secretsMu.Unlock()
+	secrets[secret] = true
+}
+
+// redact replaces the registered secrets in s.
+func redact(s string) string {
+	secretsMu.Lock()
+	defer secretsMu.Unlock()
+	for secret := range secrets {
+		s = strings.ReplaceAll(s, secret, Redacted)
+	}
+	return s
+}
+
+// redactedError redacts the secrets in the message of an error, which
+// still unwraps to it.
+type redactedError struct {
+	err error
+}
+
+func (e redactedError) Error() string {
+	return redact(e.err.Error())
+}
+
+func (e redactedError) Unwrap() error {
+	return e.err
+}
+
+// explainf prints why a listen was matched or not to stderr with -explain.
+func explainf(listen Listen, format string, args ...interface{}) {
+	if explainMatch {
+		fmt.Fprintf(logOutput, "(explain) %s: %s\n", listen, fmt.Sprintf(format, args...))
+	}
+}
+
+// debugf prints a debug message in verbose mode, with secrets redacted.
+func debugf(format string, args ...interface{}) {
+	if verbosePrint {
+		fmt.Print(redact(fmt.Sprintf("(debug) "+format, args...)))
+	}
+}
+
+// shellQuote quotes s for POSIX shells.
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// curlCommand returns a curl command equivalent to req. The token of the
+// Authorization header is read from the token variable rather than
+// written out, and any other secret is redacted.
+func curlCommand(req *http.Request) string {
+	args := []string{"curl", "-X", req.Method}
+
+	names := make([]string, 0, len(req.Header))
+	for name := range req.Header {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		for _, value := range req.Header[name] {
+			if name == "Authorization" {
+				args = append(args, "-H", `"Authorization: Token $`+tokenEnv+`"`)
+			} else {
+				args = append(args, "-H", shellQuote(name+": "+value))
+			}
+		}
+	}
+
+	if req.GetBody != nil {
+		if body, err := req.GetBody(); err == nil {
+			data, _ := io.ReadAll(body)
+			body.Close()
+			if len(data) > 0 {
+				args = append(args, "--data-raw", shellQuote(string(data)))
+			}
+		}
+	}
+
+	args = append(args, shellQuote(req.URL.String()))
+	return redact(strings.Join(args, " "))
+}
+
+// printCurl prints the curl command of a request with -print-curl.
+func printCurl(req *http.Request) {
+	if printCurls {
+		fmt.Fprintf(logOutput, "(curl) %s\n", curlCommand(req))
+	}
+}
@@ -3,15 +3,19 @@
 package main
 
 import (
-	"bytes"
+	"context"
 	"encoding/json"
 	"flag"
 	"fmt"
-	"io"
-	"net/http"
+	"net/url"
 	"os"
 	"regexp"
+	"sort"
+	"strconv"
+	"strings"
 	"time"
+
+	"golang.org/x/text/unicode/norm"
 )
 
 // Maximum value of an int64.
@@ -25,10 +29,69 @@ const ListenBrainzAPI = "https://api.listenbrainz.org/1"
 // Defaults to the maximum of MAX_ITEMS_PER_GET.
 const ItemsPerPage = 1000
 
+// TokenEnv names the environment variable holding the ListenBrainz API token.
+const TokenEnv = "LISTENBRAINZ_TOKEN"
+
+// APIEnv names the environment variable overriding ListenBrainzAPI, for
+// instance to point at a mock server in tests. -api-url takes precedence.
+const APIEnv = "LISTENBRAINZ_API_URL"
+
+// SafeEnv names the environment variable that, when set to anything but
+// "0", turns deletions into dry runs unless -force is given.
+const SafeEnv = "BRAINZ_SAFE"
+
+// AdditionalInfo describes how a listen was submitted.
+type AdditionalInfo struct {
+	SubmissionClient string `json:"submission_client,omitempty"`
+	MediaPlayer      string `json:"media_player,omitempty"`
+	ListeningFrom    string `json:"listening_from,omitempty"`
+	MusicService     string `json:"music_service,omitempty"`
+}
+
+// UnmarshalJSON decodes additional_info, which is free-form, ignoring the
+// unknown fields even with -strict.
+func (info *AdditionalInfo) UnmarshalJSON(data []byte) error {
+	type lenient AdditionalInfo
+	return json.Unmarshal(data, (*lenient)(info))
+}
+
+// Sources lists the non-empty submission sources of a listen.
+func (info AdditionalInfo) Sources() []string {
+	var sources []string
+	for _, source := range []string{info.SubmissionClient, info.ListeningFrom,
+		info.MediaPlayer, info.MusicService} {
+		if source != "" {
+			sources = append(sources, source)
+		}
+	}
+	return sources
+}
+
+// MappedArtist is an artist credited in an MBIDMapping.
+type MappedArtist struct {
+	Name       string `json:"artist_credit_name"`
+	MBID       string `json:"artist_mbid"`
+	JoinPhrase string `json:"join_phrase"`
+}
+
+// MBIDMapping links a listen to MusicBrainz entities.
+type MBIDMapping struct {
+	RecordingMBID  string         `json:"recording_mbid,omitempty"`
+	RecordingName  string         `json:"recording_name,omitempty"`
+	ReleaseMBID    string         `json:"release_mbid,omitempty"`
+	ArtistMBIDs    []string       `json:"artist_mbids,omitempty"`
+	Artists        []MappedArtist `json:"artists,omitempty"`
+	CAAID          int64          `json:"caa_id,omitempty"`
+	CAAReleaseMBID string         `json:"caa_release_mbid,omitempty"`
+}
+
 // Track describes a music track
 type Track struct {
-	Name   string `json:"track_name"`
-	Artist string `json:"artist_name"`
+	Name    string         `json:"track_name"`
+	Artist  string         `json:"artist_name"`
+	Release string         `json:"release_name,omitempty"`
+	Info    AdditionalInfo `json:"additional_info"`
+	Mapping *MBIDMapping   `json:"mbid_mapping,omitempty"`
 }
 
 // Listen describes the Recording of a Track listened at a given ListenedAt time.
@@ -36,6 +99,10 @@ type Listen struct {
 	Recording  string `json:"recording_msid"`
 	Track      Track  `json:"track_metadata"`
 	ListenedAt int64  `json:"listened_at"`
+	InsertedAt int64  `json:"inserted_at,omitempty"`
+	UserName   string `json:"user_name,omitempty"`
+	PlayingNow bool   `json:"playing_now,omitempty"`
+	CoverArt   string `json:"cover_art_url,omitempty"`
 }
 
 // Time the Track/Recording was listened to.
@@ -43,15 +110,38 @@ func (listen Listen) Time() time.Time {
 	return time.Unix(listen.ListenedAt, 0)
 }
 
+// NowPlaying reports whether the listen is playing now, having no time.
+func (listen Listen) NowPlaying() bool {
+	return listen.ListenedAt == 0
+}
+
 func (listen Listen) String() string {
-	return "<" + listen.Recording + "> " + listen.Track.Artist + " - \"" + listen.Track.Name + "\""
+	s := "<" + listen.Recording + "> " + listen.Track.Artist + " - \"" + listen.Track.Name + "\""
+	if listen.NowPlaying() {
+		s += " (now playing)"
+	}
+	return s
+}
+
+// timed returns the listens that have a time, skipping the ones playing now.
+func timed(listens []Listen) []Listen {
+	var result []Listen
+	for _, listen := range listens {
+		if !listen.NowPlaying() {
+			result = append(result, listen)
+		}
+	}
+	return result
 }
 
 // Payload contains a set of Listen's.
 type Payload struct {
-	Count   int      `json:"count"`
-	Latest  int      `json:"latest_listen_ts"`
-	Listens []Listen `json:"listens"`
+	Count      int      `json:"count"`
+	Latest     int      `json:"latest_listen_ts"`
+	Oldest     int      `json:"oldest_listen_ts"`
+	Listens    []Listen `json:"listens"`
+	UserID     string   `json:"user_id"`
+	PlayingNow bool     `json:"playing_now"`
 }
 
 // Listens contains a Payload describing a set of Listen's.
@@ -66,106 +156,247 @@ func (listens *Listens) length() int {
 	return 0
 }
 
-func deleteListen(listen Listen) bool {
-	url := ListenBrainzAPI + "/delete-listen"
+func lastTimestamp(listens []Listen) int64 {
+	return listens[len(listens)-1].ListenedAt
+}
 
-	// Create a payload to send in the request
-	payload := map[string]string{
-		"listened_at":    fmt.Sprintf("%d", listen.ListenedAt),
-		"recording_msid": listen.Recording,
-	}
+// listenKey identifies a unique Listen.
+type listenKey struct {
+	ListenedAt int64
+	Recording  string
+}
 
-	jsonpayload, err := json.Marshal(payload)
-	if err != nil {
-		panic(err)
-	}
+func (listen Listen) key() listenKey {
+	return listenKey{listen.ListenedAt, listen.Recording}
+}
 
-	// Create a new http get request
-	req, err := http.NewRequest("post", url, bytes.NewBuffer(jsonpayload))
-	if err != nil {
-		fmt.Println("error creating request:", err)
-		return false
+func getListens(max int64) Listens {
+	var page Listens
+	if loadState != "" {
+		page = stateListens(loadedListens, max)
+	} else if listens, ok := prefetched[userName]; ok {
+		page = stateListens(listens, max)
+	} else {
+		sleepJitter()
+		page = client.GetListens(userName, max)
 	}
-	req.Header.Set("content-type", "application/json")
-	req.Header.Set("authorization", fmt.Sprintf("token %s", os.Getenv("brainz_token")))
-
-	// Make the request
-	client := &http.Client{}
-	resp, err := client.Do(req)
-	if err != nil {
-		panic(err)
+	if dumpState != "" {
+		fetchedListens = append(fetchedListens, page.Payload.Listens...)
 	}
-	defer resp.Body.Close()
+	return page
+}
+
+func getAllListens() []Listen {
+	return collectListens(make([]Listen, 0, prefetchCount()), getListens, nil)
+}
 
-	if verbosePrint {
-		fmt.Printf("(debug) deletelisten(%s, %s): response status: %s\n",
-			listen.Time(), listen.Recording, resp.Status)
+// prefetchCount returns the number of listens getAllListens is expected
+// to fetch, or zero when unknown.
+func prefetchCount() int {
+	if maxCount != MaxInt64 || !cutOffTime.IsZero() {
+		return 0
 	}
+	if loadState != "" {
+		return len(loadedListens)
+	}
+	if listens, ok := prefetched[userName]; ok {
+		return len(listens)
+	}
+	count, err := client.GetListenCount(userName)
+	if err != nil {
+		debugf("failed getting listen count: %s\n", err)
+		return 0
+	}
+	return int(count)
+}
 
-	return resp.Status == "200 ok"
+func getMatchedListens() []Listen {
+	return collectListens(nil, getListens, newMatcher())
 }
 
-func lastTimestamp(listens []Listen) int64 {
-	return listens[len(listens)-1].ListenedAt
+// getListensBefore returns all listens older than before, paging from
+// it on the server instead of from the latest listen.
+func getListensBefore(before time.Time) []Listen {
+	return collectListens(nil, func(max int64) Listens {
+		if max == 0 {
+			max = before.Unix()
+		}
+		return getListens(max)
+	}, nil)
 }
 
-func getAllListens() []Listen {
+// getOldestListens returns the n oldest listens, oldest first, paging
+// from the earliest one. Since min_ts is exclusive, the next page is
+// requested from the newest timestamp minus one, and the listens already
+// seen are dropped.
+func getOldestListens(n int) []Listen {
+	if loadState != "" {
+		listens := timed(loadedListens)
+		sort.SliceStable(listens, func(i, j int) bool {
+			return listens[i].ListenedAt < listens[j].ListenedAt
+		})
+		if len(listens) > n {
+			listens = listens[:n]
+		}
+		return listens
+	}
+
 	var listens []Listen
-	timestamp := int64(0)
-	for {
-		page := getListens(timestamp)
+	seen := make(map[listenKey]bool)
+	min := int64(1)
+	for len(listens) < n {
+		page := client.GetListensAfter(userName, min)
 		if page.length() == 0 {
 			break
 		}
-		timestamp = lastTimestamp(page.Payload.Listens)
+		sort.SliceStable(page.Payload.Listens, func(i, j int) bool {
+			return page.Payload.Listens[i].ListenedAt < page.Payload.Listens[j].ListenedAt
+		})
+		added := 0
 		for _, listen := range page.Payload.Listens {
-			listens = append(listens, listen)
-			if int64(len(listens)) >= maxCount {
-				return listens
+			if seen[listen.key()] || listen.NowPlaying() {
+				continue
+			}
+			seen[listen.key()] = true
+			added++
+			fetchedTotal++
+			if listen.ListenedAt > newestFetched {
+				newestFetched = listen.ListenedAt
 			}
+			if len(listens) < n {
+				listens = append(listens, listen)
+			}
+		}
+		next := page.Payload.Listens[page.length()-1].ListenedAt
+		if added > 0 {
+			next--
+		}
+		// A page bringing nothing new without moving min_ts forward would
+		// be requested forever.
+		if added == 0 && next <= min {
+			fmt.Printf("Warning: listens out of order, the page after %d ends at %d, stopping.\n", min, next)
+			break
 		}
+		min = next
 	}
 	return listens
 }
 
-func getListens(max int64) Listens {
-	url := fmt.Sprintf("%s/user/%s/listens?count=%d",
-		ListenBrainzAPI, userName, ItemsPerPage)
-
-	if max > 0 {
-		url = fmt.Sprintf("%s&max_ts=%d", url, max)
+// getListensAt returns the matched listens at exactly at, requesting only
+// the listens between the seconds around it.
+func getListensAt(at time.Time) []Listen {
+	var page Listens
+	if loadState != "" {
+		page = stateListens(loadedListens, at.Unix()+1)
+	} else {
+		page = client.GetListensBetween(userName, at.Unix()-1, at.Unix()+1)
 	}
 
-	client := &http.Client{}
-
-	req, err := http.NewRequest("GET", url, nil)
-	if err != nil {
-		fmt.Println("Error creating request:", err)
-		return Listens{}
+	match := newMatcher()
+	var listens []Listen
+	for _, listen := range page.Payload.Listens {
+		if listen.ListenedAt == at.Unix() && match(listen) {
+			listens = append(listens, listen)
+		}
 	}
+	return listens
+}
 
-	resp, err := client.Do(req)
-	if err != nil {
-		fmt.Println("Error sending request:", err)
-		return Listens{}
-	}
-	defer resp.Body.Close()
+// collectListens pages through listens newest-first using fetch, appending
+// to listens the ones accepted by match, or all of them when match is nil.
+// It stops after -c listens were fetched or -match-limit listens were kept.
+func collectListens(listens []Listen, fetch func(max int64) Listens, match func(Listen) bool) []Listen {
+	eachListen(fetch, match, func(listen Listen) bool {
+		listens = append(listens, listen)
+		return matchLimit <= 0 || int64(len(listens)) < matchLimit
+	})
+	return listens
+}
 
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		fmt.Println("Error reading response body:", err)
-		return Listens{}
+// eachListen pages through listens newest-first using fetch, passing the
+// ones accepted by match, or all of them when match is nil, to yield
+// until it returns false. It stops at the -since-* cutoff, or after -c
+// listens were fetched.
+func eachListen(fetch func(max int64) Listens, match func(Listen) bool, yield func(Listen) bool) {
+	defer endProgress()
+	var fetched int64
+	var duplicate func(Listen) bool
+	if dedupeOn != "" {
+		duplicate = newDeduper(dedupeOn)
 	}
+	walkListens(fetch, cutOffTime, func(listen Listen) bool {
+		fetchedTotal++
+		if listen.ListenedAt > newestFetched {
+			newestFetched = listen.ListenedAt
+		}
+		updateProgress(fetchedTotal)
+		fetched++
 
-	var listens Listens
+		matched := match == nil || match(listen)
+		if matched && duplicate != nil && duplicate(listen) {
+			explainf(listen, "duplicate under -dedupe-on %s", dedupeOn)
+			matched = false
+		}
+		if matched && !yield(listen) {
+			return false
+		}
+		return fetched < maxCount
+	})
+}
 
-	err = json.Unmarshal(body, &listens)
-	if err != nil {
-		fmt.Println("Error:", err)
-		return Listens{}
+// walkListens pages through listens newest-first using fetch, passing
+// each one to visit until it returns false, or until a listen older than
+// cutOff, unless zero. It uses no global state, so that the listens of
+// several users can be walked at once.
+//
+// Since max_ts is exclusive, the next page is requested with the last
+// timestamp plus one, so listens sharing the last second of a page are
+// not skipped, and the ones of the previous page are dropped. Only the
+// keys of the previous page are kept, so that memory stays bounded.
+func walkListens(fetch func(max int64) Listens, cutOff time.Time, visit func(Listen) bool) {
+	var seen map[listenKey]bool
+	timestamp := int64(0)
+	for {
+		page := fetch(timestamp)
+		if page.length() == 0 {
+			return
+		}
+		previous := seen
+		seen = make(map[listenKey]bool, page.length())
+		added := 0
+		for _, listen := range page.Payload.Listens {
+			duplicate := previous[listen.key()] || seen[listen.key()]
+			seen[listen.key()] = true
+			if duplicate {
+				continue
+			}
+			added++
+			if !listen.NowPlaying() && listen.Time().Before(cutOff) {
+				explainf(listen, "older than the cutoff, stopping")
+				return
+			}
+			if !visit(listen) {
+				return
+			}
+		}
+		next := lastTimestamp(page.Payload.Listens)
+		if added > 0 {
+			next++
+		}
+		// Pages should end older than the max_ts they were asked for. When
+		// one does not, ask again below the same max_ts while it brings new
+		// listens, and stop once it does not rather than loop on it.
+		if timestamp != 0 && next >= timestamp {
+			if added == 0 {
+				endProgress()
+				fmt.Printf("Warning: listens out of order, the page before %d ends at %d, stopping.\n",
+					timestamp, lastTimestamp(page.Payload.Listens))
+				return
+			}
+			next = timestamp
+		}
+		timestamp = next
 	}
-
-	return listens
 }
 
 var (
@@ -175,8 +406,138 @@ var (
 	searchPattern string
 	verbosePrint  bool
 	showUsage     bool
+	tokenCheck    bool
+	dayCounts     bool
+	timeZone      string
+	location      *time.Location
+	usePager      bool
+	albumPattern  string
+	albumMissing  bool
+	sinceDays     int
+	sinceHours    int
+	sinceMinutes  int
+	cutOffTime    time.Time
+	matchLimit    int64
+	jobsFile      string
+	asciiOutput   bool
+	summaryStats  bool
+	deleteBefore  string
+	beforeTime    time.Time
+	dumpState     string
+	loadState     string
+	alignOutput   bool
+	patternFile   string
+	matchCounts   bool
+	maxDelete     int64
+	sourcePattern string
+	relativeTime  bool
+	selectedField string
+	dedupReport   bool
+	dedupWindow   time.Duration
+	failFast      bool
+	artistFilters stringList
+	artistCompare bool
+	templateFile  string
+	fetchOnly     bool
+	deleteFile    string
+	numberLocale  string
+	watchMode     bool
+	watchInterval int
+	recommendMode bool
+	diffMode      bool
+	topArtists    bool
+	normalize     bool
+	minCount      int
+	selfTestMode  bool
+	jitter        time.Duration
+	mergeState    string
+	strictJSON    bool
+	ageReport     bool
+	trackPattern  string
+	playingNow    bool
+	retryBudget   time.Duration
+	emptyRetries  int
+	otherUser     string
+	keepLast      int
+	keepPer       string
+	pinsMode      bool
+	anonymize     bool
+	hashMSID      bool
+	outputBuffer  int
+	flushInterval time.Duration
+	flushEvery    int
+	maxBodySize   int64
+	bandwidthMax  int64
+	tsvOutput     bool
+	csvExtended   bool
+	adaptivePace  bool
+	jsonStream    bool
+	tsOnly        bool
+	isoOnly       bool
+	runTimeout    time.Duration
+	pageTimeout   time.Duration
+	summaryJSON   bool
+	summaryFile   string
+	noMatchExit   int
+	artistByDay   bool
+	forceDelete   bool
+	linePrefix    string
+	lineSuffix    string
+	sinceFile     string
+	updateSince   bool
+	artistMBID    string
+	mbidMissing   bool
+	normalizeNFC  bool
+	insecureTLS   bool
+	bySource      bool
+	truncateAt    int
+	replayFile    string
+	dedupeOn      string
+	listenAt      string
+	atTime        time.Time
+	outputFile    string
+	appendOutput  bool
+	minPerDay     int
+	explainMatch  bool
+	logStdout     bool
+	heatmap       bool
+	whereExpr     string
+	whereMatch    func(Listen) bool
+	userWorkers   int
+	gapsMode      bool
+	gapThreshold  string
+	gapMin        time.Duration
+	protectRecent string
+	protectSpan   time.Duration
+	downloadCover bool
+	sampleSize    int
+	sampleSeed    int64
+	mergeWindow   time.Duration
+	chunkSpan     time.Duration
+	tokenEnv      string
+	showProgress  bool
+	byArtist      bool
+	checksumMode  bool
+	deleteOldest  int
+	apiURL        string
+	printCurls    bool
+	deleteWorkers int
+	quietNoop     bool
+	parsePath     string
 )
 
+// stringList is a flag that may be repeated.
+type stringList []string
+
+func (list *stringList) String() string {
+	return strings.Join(*list, ", ")
+}
+
+func (list *stringList) Set(value string) error {
+	*list = append(*list, value)
+	return nil
+}
+
 func init() {
 	flag.Int64Var(&maxCount, "c", MaxInt64, "Maxium number of items.")
 	flag.BoolVar(&deleteListens, "d", false, "Delete matched listens.")
@@ -184,6 +545,117 @@ func init() {
 	flag.StringVar(&userName, "u", "", "The user name or login ID.")
 	flag.StringVar(&searchPattern, "s", ".+", "The search pattern.")
 	flag.BoolVar(&showUsage, "h", false, "Show usage help.")
+	flag.BoolVar(&tokenCheck, "check-token", false, "Validate the token and show its user.")
+	flag.BoolVar(&dayCounts, "count-by-day", false, "Print matched listens per day as CSV.")
+	flag.StringVar(&timeZone, "tz", "Local", "Time zone for day boundaries.")
+	flag.BoolVar(&usePager, "pager", false, "Page output through $PAGER on a terminal.")
+	flag.StringVar(&albumPattern, "album", "", "The album search pattern.")
+	flag.BoolVar(&albumMissing, "album-missing", false, "Match listens without album with -album.")
+	flag.IntVar(&sinceDays, "since-days", 0, "Only listens from the last days.")
+	flag.IntVar(&sinceHours, "since-hours", 0, "Only listens from the last hours.")
+	flag.IntVar(&sinceMinutes, "since-minutes", 0, "Only listens from the last minutes.")
+	flag.Int64Var(&matchLimit, "match-limit", 0, "Stop after a number of matches.")
+	flag.StringVar(&jobsFile, "jobs-file", "", "Run the queries of a JSON jobs file.")
+	flag.BoolVar(&asciiOutput, "ascii", false, "Escape non-ASCII characters in output.")
+	flag.BoolVar(&summaryStats, "summary-stats", false, "Print statistics of matched listens.")
+	flag.StringVar(&deleteBefore, "delete-before", "", "Select all listens older than a time.")
+	flag.StringVar(&dumpState, "dump-state", "", "Write the fetched listens to a file.")
+	flag.StringVar(&loadState, "load-state", "", "Read listens from a file instead of fetching.")
+	flag.BoolVar(&alignOutput, "align", false, "Align output columns on a terminal.")
+	flag.StringVar(&patternFile, "pattern-file", "", "Match any search pattern of a file.")
+	flag.BoolVar(&matchCounts, "match-count", false, "Print matches per -pattern-file entry.")
+	flag.Int64Var(&maxDelete, "max-delete", 0, "Refuse deleting more than a number of listens.")
+	flag.StringVar(&sourcePattern, "source", "", "The submission source search pattern.")
+	flag.BoolVar(&relativeTime, "relative", false, "Show listen times relative to now.")
+	flag.StringVar(&selectedField, "select", "", "Print a single field of each listen.")
+	flag.BoolVar(&dedupReport, "dedup-report", false, "Print groups of near-duplicate listens.")
+	flag.DurationVar(&dedupWindow, "dedup-window", 5*time.Minute, "Time between near-duplicate listens.")
+	flag.BoolVar(&failFast, "fail-fast", false, "Stop at the first failed delete.")
+	flag.Var(&artistFilters, "artist", "The artist search pattern, may be repeated.")
+	flag.BoolVar(&artistCompare, "compare-artists", false, "Compare the listens of two -artist.")
+	flag.StringVar(&templateFile, "template-file", "", "Print matched listens with a text/template.")
+	flag.BoolVar(&fetchOnly, "fetch-only", false, "Only fetch listens and print count and timing.")
+	flag.StringVar(&deleteFile, "delete-file", "", "Select the listens of a JSON lines file.")
+	flag.StringVar(&numberLocale, "locale", "", "Locale of numbers in summaries.")
+	flag.BoolVar(&watchMode, "watch", false, "Keep printing new matching listens.")
+	flag.IntVar(&watchInterval, "interval", 30, "Seconds between -watch polls.")
+	flag.BoolVar(&recommendMode, "recommend", false, "Print recordings recommended to the user.")
+	flag.BoolVar(&diffMode, "diff", false, "Print the changes between two snapshot files.")
+	flag.BoolVar(&topArtists, "top-artists", false, "Print artists by number of matched listens.")
+	flag.BoolVar(&normalize, "normalize", false, "Group names ignoring case and whitespace.")
+	flag.IntVar(&minCount, "min-count", 0, "Hide aggregation entries with fewer listens.")
+	flag.BoolVar(&selfTestMode, "selftest", false, "Check the token, API, user and fetching.")
+	flag.DurationVar(&jitter, "jitter", 0, "Random delay of up to a duration before each page.")
+	flag.StringVar(&mergeState, "merge-state", "", "Merge the snapshot files given as arguments.")
+	flag.BoolVar(&strictJSON, "strict", false, "Fail on unknown fields in API responses.")
+	flag.BoolVar(&ageReport, "age-report", false, "Print matched listens per age range.")
+	flag.StringVar(&trackPattern, "track", "", "The track search pattern.")
+	flag.BoolVar(&playingNow, "playing-now", false, "Submit -artist and -track as playing now.")
+	flag.DurationVar(&retryBudget, "retry-budget", time.Minute, "Total time to spend retrying requests.")
+	flag.StringVar(&otherUser, "compare-users", "", "Compare the listens of -u with another user.")
+	flag.IntVar(&keepLast, "keep-last", 0, "Only keep the newest listens of each artist or track.")
+	flag.StringVar(&keepPer, "per", "artist", "Group of -keep-last.")
+	flag.BoolVar(&pinsMode, "pins", false, "Print recordings pinned by the user.")
+	flag.BoolVar(&anonymize, "anonymize", false, "Replace the user name in exported listens.")
+	flag.BoolVar(&hashMSID, "hash-msid", false, "Hash recording_msid in exported listens with -anonymize.")
+	flag.IntVar(&outputBuffer, "output-buffer", 0, "Size of the output buffer in bytes.")
+	flag.DurationVar(&flushInterval, "flush-interval", time.Second, "Interval between flushes of the output buffer.")
+	flag.IntVar(&flushEvery, "flush-every", 0, "Flush the output buffer after a number of lines.")
+	flag.Int64Var(&maxBodySize, "max-body-size", 64<<20, "Maximum size of API responses in bytes.")
+	flag.BoolVar(&tsvOutput, "tsv", false, "Print listens as tab-separated values.")
+	flag.BoolVar(&adaptivePace, "pace", false, "Spread requests evenly over the rate limit window.")
+	flag.BoolVar(&jsonStream, "json-array-stream", false, "Print listens as a JSON array, one element at a time.")
+	flag.DurationVar(&runTimeout, "timeout", 0, "Time limit of the requests of the whole run.")
+	flag.DurationVar(&pageTimeout, "page-timeout", 0, "Time limit of each page of listens.")
+	flag.BoolVar(&summaryJSON, "summary-json", false, "Write the summary of the run as JSON to stderr.")
+	flag.StringVar(&summaryFile, "summary-file", "", "Write the summary of the run as JSON to a file.")
+	flag.IntVar(&noMatchExit, "no-match-exit", 0, "Exit status when no listen matched.")
+	flag.BoolVar(&artistByDay, "artist-by-day", false, "Print the listens of the -artist per day as CSV.")
+	flag.BoolVar(&forceDelete, "force", false, "Delete even when "+SafeEnv+" is set.")
+	flag.StringVar(&linePrefix, "line-prefix", "", "Text printed before each listen.")
+	flag.StringVar(&lineSuffix, "line-suffix", "", "Text printed after each listen.")
+	flag.StringVar(&sinceFile, "since-file", "", "Only listens after the timestamp in a file.")
+	flag.BoolVar(&updateSince, "update-since-file", false, "Store the newest listen fetched in -since-file.")
+	flag.StringVar(&artistMBID, "artist-mbid", "", "MusicBrainz artist MBID.")
+	flag.BoolVar(&mbidMissing, "mbid-missing", false, "Match listens without artist MBIDs with -artist-mbid.")
+	flag.BoolVar(&insecureTLS, "insecure", false, "Do not verify TLS certificates.")
+	flag.BoolVar(&bySource, "by-source", false, "Print the submission sources of matched listens.")
+	flag.IntVar(&truncateAt, "truncate", 0, "Clip artist and track names to a number of characters.")
+	flag.StringVar(&replayFile, "replay", "", "Submit the listens of a snapshot again.")
+	flag.StringVar(&dedupeOn, "dedupe-on", "", "Key identifying duplicate listens.")
+	flag.StringVar(&listenAt, "at", "", "Select the listens at an exact time.")
+	flag.StringVar(&outputFile, "output-file", "", "Write the listing to a file.")
+	flag.BoolVar(&appendOutput, "append", false, "Append to -output-file, locking it.")
+	flag.IntVar(&minPerDay, "min-per-day", 0, "Leave out days with fewer listens from per-day counts.")
+	flag.BoolVar(&explainMatch, "explain", false, "Print why each listen matched or not to stderr.")
+	flag.BoolVar(&logStdout, "log-stdout", false, "Print the messages otherwise written to stderr to stdout.")
+	flag.BoolVar(&heatmap, "heatmap", false, "Print matched listens per weekday and hour as CSV.")
+	flag.StringVar(&whereExpr, "where", "", "Filter expression over the fields of listens.")
+	flag.BoolVar(&gapsMode, "gaps", false, "Print the spans without matched listens.")
+	flag.StringVar(&gapThreshold, "gap-threshold", "2d", "Shortest span reported by -gaps.")
+	flag.StringVar(&protectRecent, "protect-recent", "", "Never delete listens newer than a duration.")
+	flag.BoolVar(&downloadCover, "download-cover", false, "Add the front cover URL of the release of listens.")
+	flag.IntVar(&sampleSize, "sample", 0, "Only print N matched listens picked at random.")
+	flag.Int64Var(&sampleSeed, "seed", 0, "Seed of -sample, random when 0.")
+	flag.DurationVar(&mergeWindow, "merge-adjacent", 0, "Count back-to-back plays of a track within a duration once.")
+	flag.DurationVar(&chunkSpan, "chunk", 0, "List by chunks of a duration, each sorted oldest first.")
+	flag.StringVar(&tokenEnv, "token-env", TokenEnv, "Environment variable holding the API token.")
+	flag.BoolVar(&showProgress, "progress", false, "Show the listens fetched so far on a terminal.")
+	flag.BoolVar(&byArtist, "group-by-artist", false, "List matched listens under each artist, most played first.")
+	flag.BoolVar(&checksumMode, "checksum", false, "Print a SHA-256 of the matched listens.")
+	flag.IntVar(&deleteOldest, "delete-oldest", 0, "Select the N oldest listens.")
+	flag.StringVar(&apiURL, "api-url", "", "Root URL of the ListenBrainz API.")
+	flag.BoolVar(&printCurls, "print-curl", false, "Print a curl command of each API request to stderr.")
+	flag.IntVar(&deleteWorkers, "delete-concurrency", 1, "Number of listens deleted at once.")
+	flag.BoolVar(&quietNoop, "summary-only-on-change", false, "Print nothing when no listen matched.")
+	flag.StringVar(&parsePath, "parse-file", "", "Print the listens of a saved API response.")
+	flag.BoolVar(&normalizeNFC, "normalize-unicode", false, "Match patterns and listens in Unicode NFC form.")
+	flag.BoolVar(&csvExtended, "csv-extended", false, "Print listens as comma-separated values with their MusicBrainz IDs.")
+	flag.Int64Var(&bandwidthMax, "bandwidth", 0, "Maximum transfer rate of API responses in bytes per second.")
+	flag.BoolVar(&tsOnly, "ts-only", false, "Give the time of JSON listens as a Unix timestamp only.")
+	flag.BoolVar(&isoOnly, "iso-only", false, "Give the time of JSON listens as an RFC 3339 string only.")
+	flag.IntVar(&emptyRetries, "retry-on-empty-page", 0, "Times to retry an empty page of listens before ending paging.")
+	flag.IntVar(&userWorkers, "concurrent-users", 1, "Fetch the listens of up to N users of -jobs-file at once.")
 }
 
 func usage() {
@@ -194,47 +666,1190 @@ func usage() {
 	fmt.Println("   -s: Search regexp pattern.")
 	fmt.Println("   -v: Debug/verbose output.")
 	fmt.Println("   -h: Show this help.")
-	os.Exit(2)
+	fmt.Println("   -check-token: Validate the token and show its user.")
+	fmt.Println("   -count-by-day: Print matched listens per day as CSV, for every day of the")
+	fmt.Println("                  -since-* window until today, days without listens included.")
+	fmt.Println("   -tz: Time zone for day boundaries (e.g. Europe/Berlin).")
+	fmt.Println("   -pager: Page output through $PAGER (default less) on a terminal.")
+	fmt.Println("   -album: Album search regexp pattern.")
+	fmt.Println("   -album-missing: Also match listens without album info with -album.")
+	fmt.Println("   -since-days: Only listens from the last N days.")
+	fmt.Println("   -since-hours: Only listens from the last N hours.")
+	fmt.Println("   -since-minutes: Only listens from the last N minutes.")
+	fmt.Println("   -match-limit: Stop after N matches, newest first.")
+	fmt.Println("   -jobs-file: Run the queries of a JSON jobs file in sequence.")
+	fmt.Println("   -ascii: Escape non-ASCII characters in text output as \\uXXXX.")
+	fmt.Println("   -summary-stats: Print statistics of the matched listens.")
+	fmt.Println("   -delete-before: Select all listens older than a timestamp or date,")
+	fmt.Println("                   ignoring -s and -album. Deletes them with -d.")
+	fmt.Println("   -dump-state: Write the fetched listens as JSON to a file, or with -d the")
+	fmt.Println("                listens to delete, before deleting them.")
+	fmt.Println("   -load-state: Read listens from a -dump-state file instead of fetching.")
+	fmt.Println("   -align: Print time, artist, track and msid in aligned columns on a terminal.")
+	fmt.Println("   -pattern-file: Match any of the regexp patterns of a file, one per line.")
+	fmt.Println("   -match-count: Print the number of matches of each -pattern-file entry.")
+	fmt.Println("   -max-delete: Delete nothing when more than N listens match.")
+	fmt.Println("   -source: Submission client, player or service regexp pattern.")
+	fmt.Println("   -relative: Show listen times relative to now (e.g. 3d ago).")
+	fmt.Println("   -select: Print only one field: " + strings.Join(SelectFields, ", ") + ".")
+	fmt.Println("   -dedup-report: Print listens of the same track played within -dedup-window.")
+	fmt.Println("   -dedup-window: Maximum time between near-duplicate listens (default 5m).")
+	fmt.Println("   -fail-fast: Exit at the first failed delete instead of continuing.")
+	fmt.Println("   -artist: Artist regexp pattern, matching any when repeated.")
+	fmt.Println("   -compare-artists: Compare the listens of exactly two -artist patterns.")
+	fmt.Println("   -template-file: Print a report of the matched listens with a Go text/template.")
+	fmt.Println("   -fetch-only: Fetch listens without matching, print only count and timing.")
+	fmt.Println("   -delete-file: Select exactly the listens of a JSON lines file of")
+	fmt.Println("                 listened_at and recording_msid. Deletes them with -d.")
+	fmt.Println("   -locale: Locale of summary numbers (e.g. de_DE), defaults to $LANG.")
+	fmt.Println("   -watch: After listing, keep polling and printing new matching listens.")
+	fmt.Println("   -interval: Seconds between -watch polls (default 30).")
+	fmt.Println("   -recommend: Print the recording MBIDs recommended to the user, and their score.")
+	fmt.Println("   -diff: Print listens added (+) and removed (-) between two -dump-state")
+	fmt.Println("          files given as arguments: -diff <before> <after>.")
+	fmt.Println("   -top-artists: Print the artists of the matched listens by listen count.")
+	fmt.Println("   -normalize: Group names in -top-artists and -summary-stats ignoring case")
+	fmt.Println("               and whitespace, labelled with their most common form.")
+	fmt.Println("   -min-count: Hide -top-artists entries with fewer than N listens.")
+	fmt.Println("   -selftest: Check the token, the API, the user and fetching a page.")
+	fmt.Println("   -jitter: Wait a random delay of up to a duration (e.g. 500ms) before each page.")
+	fmt.Println("   -merge-state: Merge the -dump-state files given as arguments into one file:")
+	fmt.Println("                 -merge-state <output> <input>...")
+	fmt.Println("   -strict: Fail on unknown fields in API responses, to catch API changes.")
+	fmt.Println("   -age-report: Print matched listens of today, this week, month, year and older.")
+	fmt.Println("   -track: Track name regexp pattern.")
+	fmt.Println("   -playing-now: Submit the track named by -artist and -track, and -album if")
+	fmt.Println("                 given, as playing now.")
+	fmt.Println("   -retry-budget: Total time spent waiting to retry failed requests over the")
+	fmt.Println("                  whole run (default 1m, 0 disables retries).")
+	fmt.Println("   -compare-users: Print the artists and tracks matched in the listens of both")
+	fmt.Println("                   -u and another user, and their overlap.")
+	fmt.Println("   -keep-last: Only match listens older than the newest N of their group, to")
+	fmt.Println("               delete them with -d.")
+	fmt.Println("   -per: Group of -keep-last: " + strings.Join(KeepGroups, ", ") + " (default artist).")
+	fmt.Println("   -pins: Print the recordings pinned by the user.")
+	fmt.Println("   -anonymize: Replace user_name with \"" + AnonymousUser + "\" in exported listens: the")
+	fmt.Println("               snapshots of -dump-state and -merge-state, listings, -tsv,")
+	fmt.Println("               -csv-extended, -json-array-stream and templates.")
+	fmt.Println("   -hash-msid: Also replace recording_msid with a hash of it with -anonymize.")
+	fmt.Println("   -output-buffer: Buffer output in N bytes, for consumers of piped output")
+	fmt.Println("                   (default 0, unbuffered).")
+	fmt.Println("   -flush-interval: Interval between flushes of the output buffer (default 1s,")
+	fmt.Println("                    0 disables).")
+	fmt.Println("   -flush-every: Flush the output buffer every N lines (default 0, disabled).")
+	fmt.Println("   -max-body-size: Fail on API responses larger than N bytes (default 64 MiB,")
+	fmt.Println("                   0 disables).")
+	fmt.Println("   -tsv: Print listens as tab-separated " + strings.Join(ListenColumns, ", ") + ", after a")
+	fmt.Println("         header, with tabs, newlines and backslashes escaped as \\t, \\n and \\\\.")
+	fmt.Println("   -pace: Space requests by the time left in the rate limit window divided by")
+	fmt.Println("          the requests remaining, so they last until it resets.")
+	fmt.Println("   -json-array-stream: Print listens as a JSON array, one listen per line as")
+	fmt.Println("                       they are listed, closed even when stopped by -fail-fast.")
+	fmt.Println("                       Listens are written as pages arrive, unless -d, a report,")
+	fmt.Println("                       -keep-last, -sample or -dump-state needs all of them first.")
+	fmt.Println("   -timeout: Time after which requests of the whole run fail (default 0, none).")
+	fmt.Println("   -page-timeout: Time after which a page of listens is fetched again, within")
+	fmt.Println("                  the retry budget (default 0, none).")
+	fmt.Println("   -summary-json: Write the fetched, matched, deleted, failed and skipped listens")
+	fmt.Println("                  and the duration_seconds of the run as a JSON object to stderr.")
+	fmt.Println("   -summary-file: Write the JSON summary of the run to a file instead.")
+	fmt.Println("   -no-match-exit: Exit status when no listen matched (default 0, success; 1")
+	fmt.Println("                   makes an empty match an error, like grep).")
+	fmt.Println("   -artist-by-day: Print date,count CSV of the matched listens of one -artist for")
+	fmt.Println("                   every day of the -since-* window until today.")
+	fmt.Println("   -force: Delete with -d even when " + SafeEnv + " is set, which otherwise turns")
+	fmt.Println("           deletions, including those of jobs, into dry runs.")
+	fmt.Println("   -line-prefix: Text printed before each listen of the text listing, such as a")
+	fmt.Println("                 user name or job id for aggregated logs.")
+	fmt.Println("   -line-suffix: Text printed after each listen of the text listing.")
+	fmt.Println("   -since-file: Only listens after the Unix timestamp stored in a file; all of")
+	fmt.Println("                them when the file does not exist yet.")
+	fmt.Println("   -update-since-file: Store the timestamp of the newest listen fetched in")
+	fmt.Println("                       -since-file after the run.")
+	fmt.Println("   -artist-mbid: Only listens mapped to a MusicBrainz artist MBID.")
+	fmt.Println("   -mbid-missing: Also match listens without artist MBIDs with -artist-mbid.")
+	fmt.Println("   -insecure: Do not verify TLS certificates, for test instances with")
+	fmt.Println("              self-signed ones. Never use it against the public API.")
+	fmt.Println("   -by-source: Print the matched listens per submission client, or other source")
+	fmt.Println("               known, by descending count.")
+	fmt.Println("   -truncate: Clip artist and track names of the text listing to N characters,")
+	fmt.Println("              ending with an ellipsis. Exports are not clipped.")
+	fmt.Println("   -replay: Submit the listens of a -dump-state snapshot again with their")
+	fmt.Println("            original times, to restore deleted listens.")
+	fmt.Println("   -dedupe-on: Key identifying duplicate listens: " + strings.Join(DedupKeys, ", ") + ".")
+	fmt.Println("               Only the newest listen of duplicates is matched, and -dedup-report")
+	fmt.Println("               groups them (default track-window for the report, and for")
+	fmt.Println("               matching only the same listen fetched twice).")
+	fmt.Println("   -at: Only the matched listens at an exact timestamp, RFC3339 time or date,")
+	fmt.Println("        e.g. to check that a listen was deleted.")
+	fmt.Println("   -output-file: Write the listing and reports to a file instead of stdout.")
+	fmt.Println("   -append: Append to -output-file instead of truncating it, holding an")
+	fmt.Println("            exclusive lock on it so that concurrent runs do not interleave.")
+	fmt.Println("   -min-per-day: Leave out the days with fewer than N listens from -count-by-day")
+	fmt.Println("                 and -artist-by-day.")
+	fmt.Println("   -explain: Print to stderr the filters each listen fetched passed, and the")
+	fmt.Println("             first one it failed, to tune queries before deleting.")
+	fmt.Println("   -log-stdout: Print -explain traces and the -summary-json summary to stdout,")
+	fmt.Println("                prefixed with (explain) and (summary), for CI capturing only")
+	fmt.Println("                stdout. Errors and warnings are always printed to stdout.")
+	fmt.Println("   -heatmap: Print a CSV matrix of matched listens per day of the week, from")
+	fmt.Println("             Monday, and hour of the day in the -tz time zone.")
+	fmt.Println("   -where: Only listens matching an expression, e.g.")
+	fmt.Println("           'artist =~ \"beatles\" && year == 2023 && hour >= 20', comparing")
+	fmt.Println("           artist, track, album, msid and source with ==, !=, =~ and !~, and")
+	fmt.Println("           ts, year, month, day, hour, minute and weekday (1 for Monday) with")
+	fmt.Println("           ==, !=, <, <=, > and >=, combined with &&, ||, ! and parentheses.")
+	fmt.Println("   -gaps: Print the spans longer than -gap-threshold without matched listens,")
+	fmt.Println("          such as when scrobbling was broken, up to now.")
+	fmt.Println("   -gap-threshold: Shortest span reported by -gaps, e.g. 12h or 2d (default 2d).")
+	fmt.Println("   -protect-recent: Never delete the listens newer than a duration, e.g. 1d or")
+	fmt.Println("                    12h, even when matched with -d; they are reported as protected.")
+	fmt.Println("   -download-cover: Add the front cover URL of the release of matched listens,")
+	fmt.Println("                    from the Cover Art Archive, to the listing and exports.")
+	fmt.Println("   -sample: Only print N of the matched listens, picked at random, to spot-check")
+	fmt.Println("            a large match before deleting it.")
+	fmt.Println("   -seed: Seed -sample to pick the same listens on every run (default random).")
+	fmt.Println("   -merge-adjacent: Count the back-to-back plays of the same track, each within")
+	fmt.Println("                    a duration (e.g. 10m) of the one before, as one listen in")
+	fmt.Println("                    reports and -summary-stats. The listing is unaffected.")
+	fmt.Println("   -chunk: List matched listens by chunks of a duration (e.g. 720h), newest chunk")
+	fmt.Println("           first, each sorted oldest first and printed once fetched, holding a")
+	fmt.Println("           single chunk in memory. Listens are not sorted across chunks.")
+	fmt.Println("   -token-env: Read the API token from another environment variable than")
+	fmt.Println("               " + TokenEnv + ", e.g. to switch between accounts.")
+	fmt.Println("   -progress: Show the number of listens fetched so far on stderr, only when it")
+	fmt.Println("              is a terminal, so that redirected or piped output stays clean.")
+	fmt.Println("   -group-by-artist: List the matched listens under a header of each artist,")
+	fmt.Println("                     artists by descending listens and listens newest first.")
+	fmt.Println("   -checksum: Print a SHA-256 of the timestamps and msids of the matched listens,")
+	fmt.Println("              whatever their order, to tell whether they changed between runs.")
+	fmt.Println("   -delete-oldest: Select the N oldest listens, paging from the earliest one and")
+	fmt.Println("                   ignoring the search flags. Deletes them with -d.")
+	fmt.Println("   -api-url: Root URL of the ListenBrainz API, defaulting to $" + APIEnv)
+	fmt.Println("             or else " + ListenBrainzAPI + ".")
+	fmt.Println("   -print-curl: Print an equivalent curl command of each API request to stderr,")
+	fmt.Println("                reading the token from its variable instead of printing it.")
+	fmt.Println("   -delete-concurrency: Delete up to N listens at once (default 1), independently")
+	fmt.Println("                        of -concurrent-users, to go easy on the write endpoints.")
+	fmt.Println("   -summary-only-on-change: Print nothing, not even the summary, when no listen")
+	fmt.Println("                            matched, e.g. for cron to only mail on changes.")
+	fmt.Println("                            Warnings and errors are still printed.")
+	fmt.Println("   -parse-file: Decode a saved response of the listens API, honoring -strict, and")
+	fmt.Println("                print its listens, without any request.")
+	fmt.Println("   -normalize-unicode: Normalize patterns and listens to Unicode NFC before")
+	fmt.Println("                       matching, so composed and decomposed accents match.")
+	fmt.Println("   -csv-extended: Print listens as comma-separated values, like -tsv, with the")
+	fmt.Println("                  " + strings.Join(ExtendedColumns, ", ") + " columns added.")
+	fmt.Println("   -bandwidth: Read API responses at most N bytes per second, as transferred,")
+	fmt.Println("               for metered connections (default: unlimited).")
+	fmt.Println("   -ts-only: Give the time of -json-array-stream listens only as the Unix")
+	fmt.Println("             timestamp listened_at (default: also listened_at_iso).")
+	fmt.Println("   -iso-only: Give the time of -json-array-stream listens only as the RFC 3339")
+	fmt.Println("              listened_at_iso, in the -tz time zone.")
+	fmt.Println("   -retry-on-empty-page: Retry an empty page of listens up to N times, within")
+	fmt.Println("                         the retry budget, before taking it as the end of the")
+	fmt.Println("                         history, against flaky truncated exports (default 0).")
+	fmt.Println("   -concurrent-users: Fetch the listens of up to N users of -jobs-file at once")
+	fmt.Println("                      before running the jobs in sequence (default 1).")
+	exit(2)
+}
+
+// compileRegexp compiles a case-insensitive pattern, in Unicode NFC form
+// with -normalize-unicode like the listens it is matched against.
+func compileRegexp(pattern string) (*regexp.Regexp, error) {
+	if normalizeNFC {
+		pattern = norm.NFC.String(pattern)
+	}
+	return regexp.Compile("(?i)" + pattern)
+}
+
+func compilePattern(pattern string) *regexp.Regexp {
+	re, err := compileRegexp(pattern)
+	if err != nil {
+		fmt.Println("Error:", err)
+		exit(1)
+	}
+	return re
+}
+
+func matchSource(source *regexp.Regexp, listen Listen) bool {
+	for _, s := range listen.Track.Info.Sources() {
+		if source.MatchString(s) {
+			return true
+		}
+	}
+	return false
 }
 
-func brainz() {
-	var listens []Listen = getAllListens()
+func matchAny(res []*regexp.Regexp, s string) bool {
+	for _, re := range res {
+		if re.MatchString(s) {
+			return true
+		}
+	}
+	return false
+}
+
+func matchAlbum(album *regexp.Regexp, listen Listen) bool {
+	if listen.Track.Release == "" {
+		return albumMissing
+	}
+	return album.MatchString(listen.Track.Release)
+}
+
+// matchArtistMBID reports whether mbid is one of the artists a listen is
+// mapped to, or -mbid-missing for listens not mapped to any.
+func matchArtistMBID(mbid string, listen Listen) bool {
+	if listen.Track.Mapping == nil || len(listen.Track.Mapping.ArtistMBIDs) == 0 {
+		return mbidMissing
+	}
+	for _, artist := range listen.Track.Mapping.ArtistMBIDs {
+		if strings.EqualFold(artist, mbid) {
+			return true
+		}
+	}
+	return false
+}
+
+// normalized returns a listen with its names in Unicode NFC form, for
+// matching with -normalize-unicode.
+func normalized(listen Listen) Listen {
+	listen.Track.Name = norm.NFC.String(listen.Track.Name)
+	listen.Track.Artist = norm.NFC.String(listen.Track.Artist)
+	listen.Track.Release = norm.NFC.String(listen.Track.Release)
+	return listen
+}
+
+// rule is a condition of the search flags, named after its flag.
+type rule struct {
+	flag  string
+	match func(Listen) bool
+}
+
+// newMatcher compiles the search flags into a Listen predicate, which
+// traces the rules passed and failed by each listen with -explain.
+func newMatcher() func(Listen) bool {
+	search := compilePattern(searchPattern)
+	rules := []rule{{"-s", func(listen Listen) bool {
+		return search.MatchString(listen.String())
+	}}}
+	if albumPattern != "" {
+		album := compilePattern(albumPattern)
+		rules = append(rules, rule{"-album", func(listen Listen) bool {
+			return matchAlbum(album, listen)
+		}})
+	}
+	if sourcePattern != "" {
+		source := compilePattern(sourcePattern)
+		rules = append(rules, rule{"-source", func(listen Listen) bool {
+			return matchSource(source, listen)
+		}})
+	}
+	if len(artistFilters) > 0 {
+		var artists []*regexp.Regexp
+		for _, pattern := range artistFilters {
+			artists = append(artists, compilePattern(pattern))
+		}
+		rules = append(rules, rule{"-artist", func(listen Listen) bool {
+			return matchAny(artists, listen.Track.Artist)
+		}})
+	}
+	if trackPattern != "" {
+		track := compilePattern(trackPattern)
+		rules = append(rules, rule{"-track", func(listen Listen) bool {
+			return track.MatchString(listen.Track.Name)
+		}})
+	}
+	if artistMBID != "" {
+		rules = append(rules, rule{"-artist-mbid", func(listen Listen) bool {
+			return matchArtistMBID(artistMBID, listen)
+		}})
+	}
+	if whereMatch != nil {
+		rules = append(rules, rule{"-where", whereMatch})
+	}
+	if patterns != nil {
+		rules = append(rules, rule{"-pattern-file", matchPatterns})
+	}
+
+	return func(listen Listen) bool {
+		if normalizeNFC {
+			listen = normalized(listen)
+		}
+		if !explainMatch {
+			for _, rule := range rules {
+				if !rule.match(listen) {
+					return false
+				}
+			}
+			return true
+		}
+
+		// With -explain, the rules passed are traced.
+		var passed []string
+		for _, rule := range rules {
+			if !rule.match(listen) {
+				if len(passed) > 0 {
+					explainf(listen, "passed %s, failed %s", strings.Join(passed, ", "), rule.flag)
+				} else {
+					explainf(listen, "failed %s", rule.flag)
+				}
+				return false
+			}
+			passed = append(passed, rule.flag)
+		}
+		explainf(listen, "passed %s", strings.Join(passed, ", "))
+		return true
+	}
+}
+
+var (
+	// fetchedTotal counts the listens fetched by collectListens.
+	fetchedTotal int
+	// newestFetched is the time of the newest listen fetched.
+	newestFetched int64
+)
+
+// brainz runs the query of the flags, returning its result.
+func brainz() *RunResult {
+	result := &RunResult{}
+	defer func(start int) { result.Fetched.Store(int64(fetchedTotal - start)) }(fetchedTotal)
+
+	if fetchOnly {
+		start := time.Now()
+		listens := getAllListens()
+		fmt.Printf("Fetched %s listens in %s.\n",
+			formatNumber(len(listens)), time.Since(start).Round(time.Millisecond))
+		return result
+	}
+
+	if chunkSpan > 0 {
+		listChunks(result)
+		return result
+	}
+
+	if streaming() {
+		streamListens(result)
+		return result
+	}
+
+	var listens []Listen
+	if deleteFile != "" {
+		var err error
+		if listens, err = readListensFile(deleteFile); err != nil {
+			fmt.Println("Error: failed reading listens:", err)
+			exit(1)
+		}
+	} else if deleteBefore != "" {
+		listens = getListensBefore(beforeTime)
+	} else if listenAt != "" {
+		listens = getListensAt(atTime)
+	} else if deleteOldest > 0 {
+		listens = getOldestListens(deleteOldest)
+	} else {
+		listens = getMatchedListens()
+	}
+	if keepLast > 0 {
+		listens = beyondLast(listens, keepLast, keepPer)
+	}
+	result.Matched.Store(int64(len(listens)))
+	if sampleSize > 0 {
+		listens = sample(listens, sampleSize, sampleSeed)
+	}
+
+	if dumpState != "" {
+		dumped := fetchedListens
+		if deleteListens {
+			// Only the listens about to be deleted, for -replay to undo it.
+			dumped = listens
+		}
+		if _, err := writeState(dumpState, dumped); err != nil {
+			fmt.Println("Error: failed writing state:", err)
+			exit(1)
+		}
+		fetchedListens = nil
+	}
+
+	if quietNoop && len(listens) == 0 && !watchMode {
+		return result
+	}
+
+	defer startPager()()
+
+	// counted are the listens of reports, back-to-back plays merged.
+	counted := listens
+	if mergeWindow > 0 {
+		counted = mergeAdjacent(listens, mergeWindow)
+	}
+
+	if dayCounts || artistByDay {
+		countByDay(counted)
+		return result
+	}
+
+	if dedupReport {
+		printDuplicates(listens)
+		return result
+	}
+
+	if artistCompare {
+		printArtistComparison(counted)
+		return result
+	}
+
+	if topArtists {
+		printTopArtists(counted)
+		return result
+	}
+
+	if bySource {
+		printSources(counted)
+		return result
+	}
+
+	if heatmap {
+		printHeatmap(counted)
+		return result
+	}
+
+	if ageReport {
+		printAgeReport(counted)
+		return result
+	}
+
+	if gapsMode {
+		printGaps(listens)
+		return result
+	}
+
+	if byArtist {
+		printByArtist(listens)
+		return result
+	}
+
+	if checksumMode {
+		fmt.Fprintln(output, checksum(listens))
+		return result
+	}
+
+	if downloadCover {
+		addCovers(listens)
+	}
+
+	if templateFile != "" {
+		if err := executeTemplate(templateFile, listens); err != nil {
+			fmt.Println("Error: failed executing template:", err)
+			exit(1)
+		}
+		return result
+	}
+
+	if deleteListens && maxDelete > 0 && int64(len(listens)) > maxDelete {
+		fmt.Printf("Error: %d listens matched, more than -max-delete %d: "+
+			"narrow the query or raise the cap.\n", len(listens), maxDelete)
+		exit(1)
+	}
+
+	w, flush := listWriter()
+	if tsvOutput {
+		fmt.Fprintln(w, tsvLine(listenColumns()))
+	} else if csvExtended {
+		fmt.Fprintln(w, csvLine(extendedColumns()))
+	}
+	var array *jsonArray
+	if jsonStream {
+		array = &jsonArray{w: w}
+		flush = func() { array.Close() }
+	}
+
+	var del *deleter
+	if deleteListens {
+		del = newDeleter(result)
+	}
 	for _, listen := range listens {
-		match, err := regexp.MatchString("(?i)"+searchPattern, listen.String())
+		if array != nil {
+			if err := array.Add(listen); err != nil {
+				fmt.Printf("Warning: failed encoding listen: %s: %s\n", listen, err)
+			}
+		} else {
+			fmt.Fprintln(w, formatListen(listen))
+		}
+		if deleteListens && listen.NowPlaying() {
+			result.Skipped.Add(1)
+			fmt.Printf("Warning: cannot delete listen playing now: %s\n", listen)
+		} else if deleteListens && protected(listen) {
+			result.Skipped.Add(1)
+			fmt.Printf("Warning: protected listen newer than -protect-recent %s: %s\n", protectRecent, listen)
+		} else if deleteListens && !del.Delete(listen) {
+			break
+		}
+	}
+	if del != nil {
+		if listen, err := del.Wait(); err != nil {
+			flush()
+			fail(fmt.Sprintf("failed deleting listen: %s", listen), err)
+		}
+	}
+	flush()
+
+	if deleteListens && jobsFile == "" {
+		counts := result.Counts()
+		skipped := ""
+		if counts.Skipped > 0 {
+			skipped = fmt.Sprintf(", %s skipped", formatNumber(counts.Skipped))
+		}
+		fmt.Printf("Deleted %s of %s listens, %s failed%s.\n", formatNumber(counts.Deleted),
+			formatNumber(counts.Matched), formatNumber(counts.Failed), skipped)
+	}
+
+	if summaryStats {
+		printStats(counted)
+	}
+	if watchMode {
+		watchListens(listens)
+	}
+	if matchCounts {
+		printPatternCounts()
+	}
+	return result
+}
+
+// protected reports whether a listen is too recent to delete with
+// -protect-recent.
+func protected(listen Listen) bool {
+	return protectSpan > 0 && listen.Time().After(time.Now().Add(-protectSpan))
+}
+
+// reporting reports whether a report flag replaces the listing.
+func reporting() bool {
+	return dayCounts || artistByDay || dedupReport || artistCompare || topArtists || bySource ||
+		heatmap || ageReport || gapsMode || byArtist || checksumMode || templateFile != "" || summaryStats || watchMode || matchCounts
+}
+
+// apiRoot returns the root URL of the API from -api-url, else APIEnv,
+// else ListenBrainzAPI.
+func apiRoot() (string, error) {
+	root := apiURL
+	if root == "" {
+		root = os.Getenv(APIEnv)
+	}
+	if root == "" {
+		return ListenBrainzAPI, nil
+	}
+	u, err := url.Parse(root)
+	if err != nil {
+		return "", err
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return "", fmt.Errorf("%q: expected an http or https URL", root)
+	}
+	return strings.TrimSuffix(root, "/"), nil
+}
+
+// safeMode reports whether deletions are disabled by SafeEnv.
+func safeMode() bool {
+	safe := os.Getenv(SafeEnv)
+	return safe != "" && safe != "0" && !forceDelete
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// parseTime parses a Unix timestamp, an RFC3339 time or a date in the
+// configured location.
+func parseTime(value string) (time.Time, error) {
+	if ts, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return time.Unix(ts, 0), nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	t, err := time.ParseInLocation(DateLayout, value, location)
+	if err != nil {
+		return t, fmt.Errorf("invalid time %q: expected a timestamp, RFC3339 or %s", value, DateLayout)
+	}
+	return t, nil
+}
+
+// parseSpan parses a duration, also accepting whole days such as "2d".
+func parseSpan(value string) (time.Duration, error) {
+	if strings.HasSuffix(value, "d") {
+		n, err := strconv.Atoi(strings.TrimSuffix(value, "d"))
+		return time.Duration(n) * 24 * time.Hour, err
+	}
+	return time.ParseDuration(value)
+}
+
+// setCutOffTime sets cutOffTime from the -since-* flags, or after the
+// timestamp of -since-file.
+func setCutOffTime() error {
+	var since time.Duration
+	set := 0
+	if sinceDays != 0 {
+		since = time.Duration(sinceDays) * 24 * time.Hour
+		set++
+	}
+	if sinceHours != 0 {
+		since = time.Duration(sinceHours) * time.Hour
+		set++
+	}
+	if sinceMinutes != 0 {
+		since = time.Duration(sinceMinutes) * time.Minute
+		set++
+	}
+	if sinceFile != "" {
+		set++
+	}
+	if set > 1 {
+		return fmt.Errorf("-since-days, -since-hours, -since-minutes and -since-file are mutually exclusive")
+	}
+	if since < 0 {
+		return fmt.Errorf("invalid negative -since-* value")
+	}
+	if since > 0 {
+		cutOffTime = time.Now().Add(-since)
+	}
+	if sinceFile != "" {
+		ts, err := readSinceFile(sinceFile)
 		if err != nil {
-			fmt.Println("Error:", err)
-			os.Exit(1)
+			return err
 		}
-		if match {
-			fmt.Println(listen)
-			if deleteListens && !deleteListen(listen) {
-				fmt.Printf("Warning: failed deleting listen: %s", listen)
-			}
+		if ts > 0 {
+			cutOffTime = time.Unix(ts+1, 0)
 		}
 	}
+	return nil
+}
+
+// pathFlags lists the flags holding file paths.
+var pathFlags = []*string{&jobsFile, &dumpState, &loadState, &patternFile, &templateFile,
+	&deleteFile, &mergeState, &summaryFile, &sinceFile, &replayFile, &outputFile, &parsePath}
+
+// expandPaths expands $VAR and ${VAR} in the path flags.
+func expandPaths() {
+	for _, path := range pathFlags {
+		*path = os.ExpandEnv(*path)
+	}
+}
+
+// exitCode is the exit status of main once its deferred calls ran.
+var exitCode int
+
+// atExit holds the functions exit runs, last added first, since os.Exit
+// skips the deferred calls of main.
+var atExit []func()
+
+// exit runs the atExit functions, e.g. flushing -output-buffer, and exits
+// with code.
+func exit(code int) {
+	for i := len(atExit) - 1; i >= 0; i-- {
+		atExit[i]()
+	}
+	os.Exit(code)
 }
 
 func main() {
+	start := time.Now()
+	defer func() {
+		if exitCode != 0 {
+			os.Exit(exitCode)
+		}
+	}()
+
 	flag.Parse()
+	expandPaths()
+
+	if logStdout {
+		logOutput = os.Stdout
+	}
 
 	if showUsage {
 		usage()
 	}
 
-	if os.Getenv("LISTENBRAINZ_TOKEN") == "" {
-		fmt.Println("Error: please define LISTENBRAINZ_TOKEN.")
-		os.Exit(1)
+	if maxCount < 1 {
+		fmt.Println("Error: invalid maxCount:", maxCount)
+		usage()
+	}
+
+	if matchLimit < 0 {
+		fmt.Println("Error: invalid matchLimit:", matchLimit)
+		usage()
 	}
 
-	if userName == "" {
-		fmt.Println("Error: username is missing.")
+	if selectedField != "" && !contains(SelectFields, selectedField) {
+		fmt.Println("Error: invalid -select field:", selectedField)
 		usage()
 	}
 
-	if maxCount < 1 {
-		fmt.Println("Error: invalid maxCount:", maxCount)
+	if maxDelete < 0 {
+		fmt.Println("Error: invalid maxDelete:", maxDelete)
+		usage()
+	}
+
+	if noMatchExit < 0 || noMatchExit > 125 {
+		fmt.Println("Error: invalid noMatchExit:", noMatchExit)
+		usage()
+	}
+
+	if runTimeout < 0 || pageTimeout < 0 {
+		fmt.Println("Error: invalid -timeout or -page-timeout.")
+		usage()
+	}
+
+	if emptyRetries < 0 {
+		fmt.Println("Error: invalid -retry-on-empty-page:", emptyRetries)
+		usage()
+	}
+
+	if bandwidthMax < 0 {
+		fmt.Println("Error: invalid -bandwidth:", bandwidthMax)
+		usage()
+	}
+
+	if maxBodySize < 0 {
+		fmt.Println("Error: invalid maxBodySize:", maxBodySize)
+		usage()
+	}
+
+	if minPerDay < 0 {
+		fmt.Println("Error: invalid minPerDay:", minPerDay)
+		usage()
+	}
+
+	if truncateAt < 0 {
+		fmt.Println("Error: invalid truncate:", truncateAt)
+		usage()
+	}
+
+	if keepLast < 0 {
+		fmt.Println("Error: invalid keepLast:", keepLast)
+		usage()
+	}
+
+	if sampleSize < 0 {
+		fmt.Println("Error: invalid -sample:", sampleSize)
+		usage()
+	}
+
+	if chunkSpan < 0 {
+		fmt.Println("Error: invalid -chunk:", chunkSpan)
+		usage()
+	}
+
+	if chunkSpan > 0 && (deleteListens || deleteBefore != "" || deleteFile != "" || listenAt != "" ||
+		deleteOldest > 0 || keepLast > 0 || sampleSize > 0 || dumpState != "" || reporting()) {
+		fmt.Println("Error: -chunk cannot be combined with -d, -delete-before, -delete-file, -delete-oldest, -at, " +
+			"-keep-last, -sample, -dump-state or reports.")
+		usage()
+	}
+
+	if sampleSize > 0 && deleteListens {
+		fmt.Println("Error: -sample cannot be combined with -d.")
+		usage()
+	}
+
+	if sampleSeed == 0 {
+		sampleSeed = time.Now().UnixNano()
+	}
+
+	if dedupeOn != "" && !contains(DedupKeys, dedupeOn) {
+		fmt.Println("Error: invalid -dedupe-on key:", dedupeOn)
+		usage()
+	}
+
+	if !contains(KeepGroups, keepPer) {
+		fmt.Println("Error: invalid -per group:", keepPer)
+		usage()
+	}
+
+	var err error
+	if location, err = time.LoadLocation(timeZone); err != nil {
+		fmt.Println("Error: invalid time zone:", err)
+		usage()
+	}
+
+	if err := setCutOffTime(); err != nil {
+		fmt.Println("Error:", err)
+		usage()
+	}
+
+	if deleteBefore != "" {
+		if beforeTime, err = parseTime(deleteBefore); err != nil {
+			fmt.Println("Error:", err)
+			usage()
+		}
+	}
+
+	if whereExpr != "" {
+		if whereMatch, err = compileWhere(whereExpr); err != nil {
+			fmt.Println("Error: invalid -where expression:", err)
+			usage()
+		}
+	}
+
+	if deleteOldest < 0 {
+		fmt.Println("Error: invalid -delete-oldest:", deleteOldest)
+		usage()
+	}
+
+	if deleteOldest > 0 && (deleteBefore != "" || deleteFile != "" || listenAt != "") {
+		fmt.Println("Error: -delete-oldest cannot be combined with -delete-before, -delete-file or -at.")
+		usage()
+	}
+
+	if listenAt != "" {
+		if deleteBefore != "" || deleteFile != "" {
+			fmt.Println("Error: -at cannot be combined with -delete-before or -delete-file.")
+			usage()
+		}
+		if atTime, err = parseTime(listenAt); err != nil {
+			fmt.Println("Error:", err)
+			usage()
+		}
+	}
+
+	if apiURL, err = apiRoot(); err != nil {
+		fmt.Println("Error: invalid API URL:", err)
+		usage()
+	}
+
+	// The client is built before the modes using it, -selftest included,
+	// so that they all get -insecure, -timeout and the retry budget.
+	client = NewClient(StaticToken(os.Getenv(tokenEnv)))
+	client.API = apiURL
+	client.Retry = NewRetryBudget(retryBudget)
+	if insecureTLS {
+		fmt.Println("Warning: -insecure: TLS certificates are not verified, " +
+			"the connection and token may be intercepted.")
+		client.HTTP.Transport = insecureTransport()
+	}
+	if bandwidthMax > 0 {
+		client.HTTP.Transport = limitTransport(client.HTTP.Transport)
+	}
+	if runTimeout > 0 {
+		var cancel context.CancelFunc
+		runContext, cancel = context.WithTimeout(runContext, runTimeout)
+		defer cancel()
+	}
+	if adaptivePace {
+		client.Pace = &Pacer{}
+	}
+
+	if parsePath != "" {
+		if err := parseFile(parsePath); err != nil {
+			fmt.Println("Error: failed parsing listens:", err)
+			exit(1)
+		}
+		return
+	}
+
+	if diffMode {
+		if flag.NArg() != 2 {
+			fmt.Println("Error: -diff requires two snapshot files.")
+			usage()
+		}
+		defer startPager()()
+		if err := diffStates(os.ExpandEnv(flag.Arg(0)), os.ExpandEnv(flag.Arg(1))); err != nil {
+			fmt.Println("Error:", err)
+			exit(1)
+		}
+		return
+	}
+
+	if selfTestMode {
+		selfTest()
+		return
+	}
+
+	if mergeState != "" {
+		if flag.NArg() == 0 {
+			fmt.Println("Error: -merge-state requires snapshot files to merge.")
+			usage()
+		}
+		inputs := flag.Args()
+		for i := range inputs {
+			inputs[i] = os.ExpandEnv(inputs[i])
+		}
+		count, err := mergeStates(mergeState, inputs)
+		if err != nil {
+			fmt.Println("Error: failed merging states:", err)
+			exit(1)
+		}
+		fmt.Printf("Merged %s listens into %s.\n", formatNumber(count), mergeState)
+		return
+	}
+
+	if tokenEnv == "" {
+		fmt.Println("Error: invalid -token-env: empty variable name.")
+		usage()
+	}
+
+	if os.Getenv(tokenEnv) == "" && (loadState == "" || (deleteListens && !safeMode())) {
+		fmt.Printf("Error: please define %s.\n", tokenEnv)
+		exit(1)
+	}
+
+	if dayCounts && deleteListens {
+		fmt.Println("Error: -count-by-day cannot be combined with -d.")
+		usage()
+	}
+
+	if topArtists && deleteListens {
+		fmt.Println("Error: -top-artists cannot be combined with -d.")
+		usage()
+	}
+
+	if heatmap && deleteListens {
+		fmt.Println("Error: -heatmap cannot be combined with -d.")
+		usage()
+	}
+
+	if bySource && deleteListens {
+		fmt.Println("Error: -by-source cannot be combined with -d.")
+		usage()
+	}
+
+	if ageReport && deleteListens {
+		fmt.Println("Error: -age-report cannot be combined with -d.")
+		usage()
+	}
+
+	if gapsMode && deleteListens {
+		fmt.Println("Error: -gaps cannot be combined with -d.")
+		usage()
+	}
+
+	if checksumMode && deleteListens {
+		fmt.Println("Error: -checksum cannot be combined with -d.")
+		usage()
+	}
+
+	if byArtist && (deleteListens || tsvOutput || csvExtended || jsonStream) {
+		fmt.Println("Error: -group-by-artist cannot be combined with -d, -tsv, -csv-extended or -json-array-stream.")
+		usage()
+	}
+
+	if gapMin, err = parseSpan(gapThreshold); err != nil || gapMin <= 0 {
+		fmt.Println("Error: invalid -gap-threshold:", gapThreshold)
+		usage()
+	}
+
+	if protectRecent != "" {
+		if protectSpan, err = parseSpan(protectRecent); err != nil || protectSpan <= 0 {
+			fmt.Println("Error: invalid -protect-recent:", protectRecent)
+			usage()
+		}
+	}
+
+	if artistCompare && (len(artistFilters) != 2 || deleteListens) {
+		fmt.Println("Error: -compare-artists requires two -artist and no -d.")
+		usage()
+	}
+
+	if artistByDay && (len(artistFilters) != 1 || deleteListens) {
+		fmt.Println("Error: -artist-by-day requires one -artist and no -d.")
 		usage()
 	}
 
-	brainz()
+	if watchMode && (deleteListens || loadState != "" || usePager || watchInterval < 1) {
+		fmt.Println("Error: -watch requires a positive -interval, and no -d, -load-state or -pager.")
+		usage()
+	}
+
+	if fetchOnly && deleteListens {
+		fmt.Println("Error: -fetch-only cannot be combined with -d.")
+		usage()
+	}
+
+	if templateFile != "" && deleteListens {
+		fmt.Println("Error: -template-file cannot be combined with -d.")
+		usage()
+	}
+
+	if dedupReport && deleteListens {
+		fmt.Println("Error: -dedup-report cannot be combined with -d.")
+		usage()
+	}
+
+	if outputBuffer < 0 || flushEvery < 0 || flushInterval < 0 {
+		fmt.Println("Error: invalid -output-buffer, -flush-interval or -flush-every.")
+		usage()
+	}
+
+	if tsvOutput && (selectedField != "" || alignOutput || relativeTime) {
+		fmt.Println("Error: -tsv cannot be combined with -select, -align or -relative.")
+		usage()
+	}
+
+	if csvExtended && (tsvOutput || selectedField != "" || alignOutput || relativeTime) {
+		fmt.Println("Error: -csv-extended cannot be combined with -tsv, -select, -align or -relative.")
+		usage()
+	}
+
+	if jsonStream && (tsvOutput || csvExtended || selectedField != "" || alignOutput || relativeTime || summaryStats) {
+		fmt.Println("Error: -json-array-stream cannot be combined with -tsv, -csv-extended, -select, -align, " +
+			"-relative or -summary-stats.")
+		usage()
+	}
+
+	if (tsOnly || isoOnly) && !jsonStream {
+		fmt.Println("Error: -ts-only and -iso-only require -json-array-stream.")
+		usage()
+	}
+
+	if tsOnly && isoOnly {
+		fmt.Println("Error: -ts-only cannot be combined with -iso-only.")
+		usage()
+	}
+
+	if appendOutput && outputFile == "" {
+		fmt.Println("Error: -append requires -output-file.")
+		usage()
+	}
+
+	if outputFile != "" && usePager {
+		fmt.Println("Error: -output-file cannot be combined with -pager.")
+		usage()
+	}
+
+	if outputBuffer > 0 && usePager {
+		fmt.Println("Error: -output-buffer cannot be combined with -pager.")
+		usage()
+	}
+
+	if updateSince && sinceFile == "" {
+		fmt.Println("Error: -update-since-file requires -since-file.")
+		usage()
+	}
+
+	if hashMSID && !anonymize {
+		fmt.Println("Error: -hash-msid requires -anonymize.")
+		usage()
+	}
+
+	if otherUser != "" && (deleteListens || loadState != "") {
+		fmt.Println("Error: -compare-users cannot be combined with -d or -load-state.")
+		usage()
+	}
+
+	if deleteWorkers < 1 {
+		fmt.Println("Error: invalid -delete-concurrency:", deleteWorkers)
+		usage()
+	}
+
+	if userWorkers < 1 {
+		fmt.Println("Error: invalid -concurrent-users:", userWorkers)
+		usage()
+	}
+
+	if userWorkers > 1 && (jobsFile == "" || loadState != "") {
+		fmt.Println("Error: -concurrent-users requires -jobs-file and no -load-state.")
+		usage()
+	}
+
+	if deleteListens && safeMode() {
+		fmt.Printf("Warning: %s is set, listing instead of deleting; use -force to delete.\n", SafeEnv)
+		deleteListens = false
+	}
+
+	if patternFile != "" {
+		if patterns, err = readPatterns(patternFile); err != nil {
+			fmt.Println("Error: failed reading patterns:", err)
+			exit(1)
+		}
+	} else if matchCounts {
+		fmt.Println("Error: -match-count requires -pattern-file.")
+		usage()
+	}
+
+	if loadState != "" {
+		if loadedListens, err = readState(loadState); err != nil {
+			fmt.Println("Error: failed reading state:", err)
+			exit(1)
+		}
+	}
+
+	closeOutput, err := openOutput()
+	if err != nil {
+		fmt.Println("Error: failed opening output:", err)
+		exit(1)
+	}
+	defer closeOutput()
+	defer bufferOutput()()
+
+	if jobsFile != "" {
+		stopPager := startPager()
+		result := runJobs(jobsFile)
+		stopPager()
+		updateSinceFile()
+		writeSummary(result, start)
+		if result.Matched.Load() == 0 {
+			exitCode = noMatchExit
+		}
+		return
+	}
+
+	if replayFile != "" {
+		replay(replayFile)
+		return
+	}
+
+	if playingNow {
+		if len(artistFilters) != 1 || trackPattern == "" {
+			fmt.Println("Error: -playing-now requires one -artist and a -track.")
+			usage()
+		}
+		submitPlayingNow()
+		return
+	}
+
+	if tokenCheck || deleteListens {
+		validation := checkToken()
+		if tokenCheck {
+			fmt.Printf("Token is valid for user: %s\n", validation.UserName)
+			if userName == "" {
+				exit(0)
+			}
+		}
+		if deleteListens && userName != "" {
+			checkDeleteUser(validation, userName)
+		}
+	}
+
+	if userName == "" && loadState == "" && deleteFile == "" {
+		fmt.Println("Error: username is missing.")
+		usage()
+	}
+
+	if recommendMode {
+		defer startPager()()
+		recommend()
+		return
+	}
+
+	if otherUser != "" {
+		defer startPager()()
+		compareUsers(otherUser)
+		return
+	}
+
+	if pinsMode {
+		defer startPager()()
+		printPins()
+		return
+	}
+
+	result := brainz()
+	updateSinceFile()
+	writeSummary(result, start)
+	if result.Matched.Load() == 0 {
+		exitCode = noMatchExit
+	}
 }
@@ -0,0 +1,230 @@
+// main_test.go: Tests of paging through listens.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// listensServer starts a mock API, reached through APIEnv, serving the
+// pages of listens returned by serve for each requested max_ts, zero for
+// the latest listens, and empty pages after 10 requests, so that paging
+// that does not stop ends anyway. It returns the max_ts of the requests
+// served.
+func listensServer(t *testing.T, serve func(max int64) []Listen) *[]int64 {
+	t.Helper()
+	var requests []int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		max, _ := strconv.ParseInt(r.URL.Query().Get("max_ts"), 10, 64)
+		requests = append(requests, max)
+		var page Listens
+		if len(requests) <= 10 {
+			page.Payload.Listens = serve(max)
+		}
+		page.Payload.Count = len(page.Payload.Listens)
+		json.NewEncoder(w).Encode(page)
+	}))
+	t.Cleanup(server.Close)
+
+	t.Setenv(APIEnv, server.URL+"/1")
+	root, err := apiRoot()
+	if err != nil {
+		t.Fatal(err)
+	}
+	client = NewClient(StaticToken("token"))
+	client.API = root
+	return &requests
+}
+
+// pageBefore returns the page of listens, sorted newest first, older
+// than max as ListenBrainz does, or the latest ones when max is zero.
+func pageBefore(listens []Listen, max int64) []Listen {
+	var page []Listen
+	for _, listen := range listens {
+		if (max == 0 || listen.ListenedAt < max) && len(page) < ItemsPerPage {
+			page = append(page, listen)
+		}
+	}
+	return page
+}
+
+// captureStdout returns what f prints to stdout.
+func captureStdout(t *testing.T, f func()) string {
+	t.Helper()
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout = w
+	defer func() { os.Stdout = stdout }()
+
+	out := make(chan string)
+	go func() {
+		data, _ := io.ReadAll(r)
+		out <- string(data)
+	}()
+	f()
+	w.Close()
+	return <-out
+}
+
+// recordings returns the msids of listens.
+func recordings(listens []Listen) []string {
+	var msids []string
+	for _, listen := range listens {
+		msids = append(msids, listen.Recording)
+	}
+	return msids
+}
+
+func TestPagingSharedTimestampAtPageEdge(t *testing.T) {
+	// Listens 995 to 1003 share one second across the edge of the first
+	// page, at 1000.
+	listens := make([]Listen, 2500)
+	for i := range listens {
+		ts := int64(1700000000 - i)
+		if i > 995 && i <= 1003 {
+			ts = 1700000000 - 995
+		}
+		listens[i] = Listen{Recording: fmt.Sprintf("msid-%d", i), ListenedAt: ts}
+	}
+	listensServer(t, func(max int64) []Listen { return pageBefore(listens, max) })
+
+	got := collectListens(nil, getListens, nil)
+	if len(got) != len(listens) {
+		t.Errorf("got %d listens, want %d", len(got), len(listens))
+	}
+	seen := make(map[string]int)
+	for _, listen := range got {
+		seen[listen.Recording]++
+	}
+	for _, listen := range listens {
+		if n := seen[listen.Recording]; n != 1 {
+			t.Errorf("listen %s at %d: got %d times, want once", listen.Recording, listen.ListenedAt, n)
+		}
+	}
+}
+
+func TestPagingPageEndingAtMaxTs(t *testing.T) {
+	latest := []Listen{{Recording: "a", ListenedAt: 300}, {Recording: "b", ListenedAt: 200}}
+	// Ends at the requested max_ts, 201, and newer than it.
+	unordered := []Listen{{Recording: "c", ListenedAt: 205}, {Recording: "d", ListenedAt: 201}}
+
+	t.Run("new listens", func(t *testing.T) {
+		served := 0
+		requests := listensServer(t, func(max int64) []Listen {
+			switch {
+			case max == 0:
+				return latest
+			case max == 201 && served == 0:
+				served++
+				return unordered
+			case max == 201:
+				return []Listen{{Recording: "e", ListenedAt: 150}}
+			}
+			return nil
+		})
+
+		var got []Listen
+		out := captureStdout(t, func() { got = collectListens(nil, getListens, nil) })
+		if want := []string{"a", "b", "c", "d", "e"}; !reflect.DeepEqual(recordings(got), want) {
+			t.Errorf("got listens %v, want %v", recordings(got), want)
+		}
+		if want := []int64{0, 201, 201, 151}; !reflect.DeepEqual(*requests, want) {
+			t.Errorf("got max_ts %v, want %v", *requests, want)
+		}
+		if strings.Contains(out, "Warning") {
+			t.Errorf("got warning %q", out)
+		}
+	})
+
+	t.Run("no new listens", func(t *testing.T) {
+		requests := listensServer(t, func(max int64) []Listen {
+			if max == 0 {
+				return latest
+			}
+			return unordered
+		})
+
+		var got []Listen
+		out := captureStdout(t, func() { got = collectListens(nil, getListens, nil) })
+		if want := []string{"a", "b", "c", "d"}; !reflect.DeepEqual(recordings(got), want) {
+			t.Errorf("got listens %v, want %v", recordings(got), want)
+		}
+		if want := []int64{0, 201, 201}; !reflect.DeepEqual(*requests, want) {
+			t.Errorf("got max_ts %v, want %v", *requests, want)
+		}
+		if !strings.Contains(out, "Warning: listens out of order") {
+			t.Errorf("got output %q, want an out of order warning", out)
+		}
+	})
+}
+
+func TestMatchLimitCountsFetched(t *testing.T) {
+	listens := []Listen{{Recording: "a", ListenedAt: 300}, {Recording: "b", ListenedAt: 200}}
+	listensServer(t, func(max int64) []Listen { return pageBefore(listens, max) })
+	defer func(limit int64) { matchLimit = limit }(matchLimit)
+	matchLimit = 1
+	fetchedTotal, newestFetched = 0, 0
+
+	got := collectListens(nil, getListens, nil)
+	if len(got) != 1 {
+		t.Errorf("got %d listens, want 1", len(got))
+	}
+	if fetchedTotal != 1 || newestFetched != 300 {
+		t.Errorf("got %d fetched, newest at %d, want 1 fetched, newest at 300", fetchedTotal, newestFetched)
+	}
+}
+
+func TestMatchLimitUpdatesSinceFile(t *testing.T) {
+	listens := []Listen{{Recording: "a", ListenedAt: 300}, {Recording: "b", ListenedAt: 200}}
+	listensServer(t, func(max int64) []Listen { return pageBefore(listens, max) })
+	defer func(limit int64, file string, update bool) {
+		matchLimit, sinceFile, updateSince = limit, file, update
+	}(matchLimit, sinceFile, updateSince)
+	matchLimit = 1
+	sinceFile, updateSince = t.TempDir()+"/since", true
+	fetchedTotal, newestFetched = 0, 0
+
+	collectListens(nil, getListens, nil)
+	updateSinceFile()
+	data, err := os.ReadFile(sinceFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.TrimSpace(string(data)); got != "300" {
+		t.Errorf("got -since-file %q, want 300", got)
+	}
+}
+
+func TestOldestListensStopWithoutProgress(t *testing.T) {
+	// Ignores min_ts, as a broken server would, and stops serving after
+	// 10 pages in case paging does not stop by itself.
+	requests := listensServer(t, func(int64) []Listen {
+		return []Listen{{Recording: "a", ListenedAt: 200}, {Recording: "b", ListenedAt: 300}}
+	})
+	defer func(state string) { loadState = state }(loadState)
+	loadState = ""
+
+	var got []Listen
+	out := captureStdout(t, func() { got = getOldestListens(10) })
+	if want := []string{"a", "b"}; !reflect.DeepEqual(recordings(got), want) {
+		t.Errorf("got listens %v, want %v", recordings(got), want)
+	}
+	if len(*requests) != 3 {
+		t.Errorf("got %d requests, want 3", len(*requests))
+	}
+	if !strings.Contains(out, "Warning: listens out of order") {
+		t.Errorf("got output %q, want an out of order warning", out)
+	}
+}
@@ -0,0 +1,317 @@
+// output.go: Text output formatting.
+
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// asciiEscape escapes the non-ASCII runes of s as \uXXXX or \UXXXXXXXX.
+func asciiEscape(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		switch {
+		case r < 0x80:
+			b.WriteRune(r)
+		case r <= 0xFFFF:
+			fmt.Fprintf(&b, "\\u%04X", r)
+		default:
+			fmt.Fprintf(&b, "\\U%08X", r)
+		}
+	}
+	return b.String()
+}
+
+// humanize renders a duration relative to now, e.g. "3d ago".
+func humanize(d time.Duration) string {
+	suffix := " ago"
+	if d < 0 {
+		d, suffix = -d, " from now"
+	}
+	switch {
+	case d < time.Minute:
+		return fmt.Sprintf("%ds", int(d/time.Second)) + suffix
+	case d < time.Hour:
+		return fmt.Sprintf("%dm", int(d/time.Minute)) + suffix
+	case d < 24*time.Hour:
+		return fmt.Sprintf("%dh", int(d/time.Hour)) + suffix
+	case d < 365*24*time.Hour:
+		return fmt.Sprintf("%dd", int(d/(24*time.Hour))) + suffix
+	default:
+		return fmt.Sprintf("%dy", int(d/(365*24*time.Hour))) + suffix
+	}
+}
+
+// Ellipsis ends the names clipped by -truncate.
+const Ellipsis = "…"
+
+// truncate clips s to n runes, the last one being an ellipsis.
+func truncate(s string, n int) string {
+	runes := []rune(s)
+	if len(runes) <= n {
+		return s
+	}
+	return string(runes[:n-1]) + Ellipsis
+}
+
+// aligned reports whether the listing is printed as aligned columns,
+// which only happens on a terminal.
+func aligned() bool {
+	return alignOutput && isTTY(os.Stdout)
+}
+
+// listWriter returns the writer of the listing and a function flushing it.
+func listWriter() (io.Writer, func()) {
+	if aligned() {
+		tw := tabwriter.NewWriter(output, 0, 0, 2, ' ', 0)
+		return tw, func() { tw.Flush() }
+	}
+	return output, func() {}
+}
+
+// NowPlayingTime is shown instead of the time of a listen playing now.
+const NowPlayingTime = "(now playing)"
+
+// formatTime renders the time of a listen as RFC3339 in the configured
+// location.
+func formatTime(listen Listen) string {
+	if listen.NowPlaying() {
+		return NowPlayingTime
+	}
+	return listen.Time().In(location).Format(time.RFC3339)
+}
+
+func relativeTimeOf(listen Listen) string {
+	if listen.NowPlaying() {
+		return NowPlayingTime
+	}
+	return humanize(time.Since(listen.Time()))
+}
+
+// SelectFields are the fields of a listen printable with -select.
+var SelectFields = []string{"artist", "track", "album", "msid", "ts", "time", "cover"}
+
+// selectField returns a single field of a listen.
+func selectField(listen Listen, field string) string {
+	switch field {
+	case "artist":
+		return listen.Track.Artist
+	case "track":
+		return listen.Track.Name
+	case "album":
+		return listen.Track.Release
+	case "msid":
+		return listen.Recording
+	case "ts":
+		return strconv.FormatInt(listen.ListenedAt, 10)
+	case "time":
+		return formatTime(listen)
+	case "cover":
+		return listen.CoverArt
+	}
+	return ""
+}
+
+// ListenColumns are the columns of the tabular exports of listens.
+var ListenColumns = []string{"ts", "rfc3339", "artist", "track", "msid"}
+
+// CoverColumn is the column added to ListenColumns with -download-cover.
+const CoverColumn = "cover"
+
+// listenColumns returns the ListenColumns of the tabular exports.
+func listenColumns() []string {
+	if downloadCover {
+		return append(ListenColumns[:len(ListenColumns):len(ListenColumns)], CoverColumn)
+	}
+	return ListenColumns
+}
+
+// listenRow returns the listenColumns of a listen.
+func listenRow(listen Listen) []string {
+	row := []string{
+		strconv.FormatInt(listen.ListenedAt, 10),
+		formatTime(listen),
+		listen.Track.Artist,
+		listen.Track.Name,
+		listen.Recording,
+	}
+	if downloadCover {
+		row = append(row, listen.CoverArt)
+	}
+	return row
+}
+
+// ExtendedColumns are the columns added to listenColumns by -csv-extended,
+// from the mapping of listens to MusicBrainz.
+var ExtendedColumns = []string{"recording_mbid", "artist_mbids", "release_mbid", "release_name"}
+
+// extendedColumns returns the listenColumns followed by the ExtendedColumns.
+func extendedColumns() []string {
+	columns := listenColumns()
+	return append(columns[:len(columns):len(columns)], ExtendedColumns...)
+}
+
+// extendedRow returns the extendedColumns of a listen, the artist MBIDs
+// separated by semicolons, and empty MBIDs for unmapped listens.
+func extendedRow(listen Listen) []string {
+	var recording, release string
+	var artists []string
+	if mapping := listen.Track.Mapping; mapping != nil {
+		recording, release, artists = mapping.RecordingMBID, mapping.ReleaseMBID, mapping.ArtistMBIDs
+	}
+	return append(listenRow(listen), recording, strings.Join(artists, ";"), release, listen.Track.Release)
+}
+
+// csvLine joins fields as a CSV record, quoting them as needed, without
+// the line ending.
+func csvLine(fields []string) string {
+	var b strings.Builder
+	w := csv.NewWriter(&b)
+	w.Write(fields)
+	w.Flush()
+	return strings.TrimSuffix(b.String(), "\n")
+}
+
+// tsvEscaper escapes the characters of TSV fields that would break rows.
+var tsvEscaper = strings.NewReplacer("\\", "\\\\", "\t", "\\t", "\n", "\\n", "\r", "\\r")
+
+// tsvLine joins fields with tabs, escaping tabs, newlines and backslashes.
+func tsvLine(fields []string) string {
+	escaped := make([]string, len(fields))
+	for i, field := range fields {
+		escaped[i] = tsvEscaper.Replace(field)
+	}
+	return strings.Join(escaped, "\t")
+}
+
+// jsonArray writes listens as the elements of a JSON array as they come,
+// one per line.
+type jsonArray struct {
+	w io.Writer
+	n int
+}
+
+// jsonListen is a listen as written by -json-array-stream, its time as a
+// Unix timestamp, an RFC 3339 string in the -tz time zone, or both by
+// default, as chosen with -ts-only and -iso-only.
+type jsonListen struct {
+	Listen
+	ListenedAt    *int64 `json:"listened_at,omitempty"`
+	ListenedAtISO string `json:"listened_at_iso,omitempty"`
+}
+
+// newJSONListen returns the jsonListen of a listen, anonymized with
+// -anonymize. Listens playing now have no RFC 3339 time.
+func newJSONListen(listen Listen) jsonListen {
+	if anonymize {
+		listen = anonymized(listen)
+	}
+	out := jsonListen{Listen: listen}
+	if !isoOnly {
+		out.ListenedAt = &listen.ListenedAt
+	}
+	if !tsOnly && !listen.NowPlaying() {
+		out.ListenedAtISO = formatTime(listen)
+	}
+	return out
+}
+
+// Add writes a listen as the next element of the array.
+func (array *jsonArray) Add(listen Listen) error {
+	data, err := json.Marshal(newJSONListen(listen))
+	if err != nil {
+		return err
+	}
+	sep := ",\n"
+	if array.n == 0 {
+		sep = "[\n"
+	}
+	array.n++
+	_, err = fmt.Fprintf(array.w, "%s%s", sep, data)
+	return err
+}
+
+// Close ends the array, writing "[]" when no listen was added.
+func (array *jsonArray) Close() error {
+	end := "\n]\n"
+	if array.n == 0 {
+		end = "[]\n"
+	}
+	_, err := io.WriteString(array.w, end)
+	return err
+}
+
+// formatListen renders a listen as a line of text output, between
+// -line-prefix and -line-suffix, anonymized with -anonymize.
+func formatListen(listen Listen) string {
+	if anonymize {
+		listen = anonymized(listen)
+	}
+	return linePrefix + listenLine(listen) + lineSuffix
+}
+
+func listenLine(listen Listen) string {
+	if tsvOutput {
+		line := tsvLine(listenRow(listen))
+		if asciiOutput {
+			line = asciiEscape(line)
+		}
+		return line
+	}
+
+	if csvExtended {
+		line := csvLine(extendedRow(listen))
+		if asciiOutput {
+			line = asciiEscape(line)
+		}
+		return line
+	}
+
+	if truncateAt > 0 {
+		listen.Track.Artist = truncate(listen.Track.Artist, truncateAt)
+		listen.Track.Name = truncate(listen.Track.Name, truncateAt)
+	}
+
+	if selectedField != "" {
+		line := selectField(listen, selectedField)
+		if asciiOutput {
+			line = asciiEscape(line)
+		}
+		return line
+	}
+
+	line := listen.String()
+	if relativeTime {
+		line = relativeTimeOf(listen) + " " + line
+	}
+	if listen.CoverArt != "" {
+		line += " " + listen.CoverArt
+	}
+	if aligned() {
+		when := formatTime(listen)
+		if relativeTime {
+			when = relativeTimeOf(listen)
+		}
+		line = strings.Join([]string{
+			when,
+			listen.Track.Artist,
+			listen.Track.Name,
+			listen.Recording,
+		}, "\t")
+		if downloadCover {
+			line += "\t" + listen.CoverArt
+		}
+	}
+	if asciiOutput {
+		line = asciiEscape(line)
+	}
+	return line
+}
@@ -0,0 +1,60 @@
+// pager.go: Output paging.
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// DefaultPager is used when $PAGER is not defined.
+const DefaultPager = "less"
+
+// output receives the listing, possibly through a pager.
+var output io.Writer = os.Stdout
+
+// paging is set while the pager runs, so that the jobs of -jobs-file
+// share the pager started for them instead of each starting one.
+var paging bool
+
+// startPager redirects output through $PAGER when stdout is a terminal
+// and no pager runs yet. The returned function must be called to wait
+// for the pager to exit.
+func startPager() func() {
+	if paging || !usePager || !isTTY(os.Stdout) {
+		return func() {}
+	}
+
+	args := strings.Fields(os.Getenv("PAGER"))
+	if len(args) == 0 {
+		args = []string{DefaultPager}
+	}
+
+	cmd := exec.Command(args[0], args[1:]...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+
+	pipe, err := cmd.StdinPipe()
+	if err != nil {
+		fmt.Println("Warning: failed starting pager:", err)
+		return func() {}
+	}
+
+	if err := cmd.Start(); err != nil {
+		fmt.Println("Warning: failed starting pager:", err)
+		return func() {}
+	}
+
+	previous := output
+	output = pipe
+	paging = true
+	return func() {
+		pipe.Close()
+		cmd.Wait()
+		output = previous
+		paging = false
+	}
+}
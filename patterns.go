@@ -0,0 +1,71 @@
+// patterns.go: Search patterns read from a file.
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"text/tabwriter"
+)
+
+// Pattern is a search pattern of a pattern file and its match count.
+type Pattern struct {
+	Source  string
+	Regexp  *regexp.Regexp
+	Matches int
+}
+
+// patterns holds the patterns of -pattern-file.
+var patterns []*Pattern
+
+// readPatterns reads one pattern per line, skipping blank lines and
+// lines starting with '#'.
+func readPatterns(path string) ([]*Pattern, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	patterns := []*Pattern{}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		re, err := compileRegexp(line)
+		if err != nil {
+			return nil, err
+		}
+		patterns = append(patterns, &Pattern{Source: line, Regexp: re})
+	}
+
+	return patterns, scanner.Err()
+}
+
+// matchPatterns reports whether any pattern matches the listen, counting
+// the matches of every pattern.
+func matchPatterns(listen Listen) bool {
+	match := false
+	for _, pattern := range patterns {
+		if pattern.Regexp.MatchString(listen.String()) {
+			pattern.Matches++
+			match = true
+		}
+	}
+	return match
+}
+
+// printPatternCounts prints the number of listens matched by each pattern.
+func printPatternCounts() {
+	w := tabwriter.NewWriter(output, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "MATCHES\tPATTERN")
+	for _, pattern := range patterns {
+		fmt.Fprintf(w, "%s\t%s\n", formatNumber(pattern.Matches), pattern.Source)
+	}
+	w.Flush()
+}
@@ -0,0 +1,86 @@
+// pins.go: Pinned recordings.
+
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// Pin is a recording pinned by a user.
+type Pin struct {
+	ID          int64  `json:"row_id"`
+	Recording   string `json:"recording_msid"`
+	MBID        string `json:"recording_mbid"`
+	Blurb       string `json:"blurb_content"`
+	Created     int64  `json:"created"`
+	PinnedUntil int64  `json:"pinned_until"`
+	Track       Track  `json:"track_metadata"`
+}
+
+// String renders a pin like a listen, followed by its blurb.
+func (pin Pin) String() string {
+	s := "<" + pin.Recording + "> " + pin.Track.Artist + " - \"" + pin.Track.Name + "\""
+	if pin.Blurb != "" {
+		s += ": " + pin.Blurb
+	}
+	return s
+}
+
+// Pins is the response of the pins endpoint.
+type Pins struct {
+	Count    int    `json:"count"`
+	Offset   int    `json:"offset"`
+	Total    int    `json:"total_count"`
+	UserName string `json:"user_name"`
+	Pins     []Pin  `json:"pinned_recordings"`
+}
+
+// GetPins returns the recordings pinned by user, newest first.
+func (c *Client) GetPins(user string) (Pins, error) {
+	var pins Pins
+
+	url := fmt.Sprintf("%s/%s/pins?count=%d", c.API, user, ItemsPerPage)
+	req, err := http.NewRequestWithContext(runContext, "GET", url, nil)
+	if err != nil {
+		return pins, err
+	}
+
+	resp, err := c.do(req)
+	if err != nil {
+		return pins, err
+	}
+	defer resp.Body.Close()
+
+	body, err := readBody(resp)
+	if err != nil {
+		return pins, err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return pins, statusError(resp)
+	}
+
+	if err := decodeJSON(body, &pins); err != nil {
+		return pins, fmt.Errorf("%s: %w", resp.Status, err)
+	}
+
+	return pins, nil
+}
+
+// printPins prints the recordings pinned by the user.
+func printPins() {
+	pins, err := client.GetPins(userName)
+	if err != nil {
+		fail("failed getting pins", err)
+	}
+
+	for _, pin := range pins.Pins {
+		line := time.Unix(pin.Created, 0).In(location).Format(time.RFC3339) + " " + pin.String()
+		if asciiOutput {
+			line = asciiEscape(line)
+		}
+		fmt.Fprintln(output, line)
+	}
+}
@@ -0,0 +1,43 @@
+// progress.go: Progress of fetching on the terminal.
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"time"
+)
+
+// ProgressInterval is the shortest time between progress updates.
+const ProgressInterval = 100 * time.Millisecond
+
+// lastProgress is the time of the last progress update, zero when none
+// is shown.
+var lastProgress time.Time
+
+// progressEnabled reports whether progress may be shown: with -progress,
+// only on stderr and only when it is a terminal, wherever stdout points,
+// so that progress never leaks into piped or redirected data. The pager
+// owns the terminal once started, so progress is off with it.
+func progressEnabled() bool {
+	return showProgress && !usePager && isTTY(os.Stderr)
+}
+
+// updateProgress shows the number of listens fetched so far, at most
+// every ProgressInterval.
+func updateProgress(fetched int) {
+	if !progressEnabled() || time.Since(lastProgress) < ProgressInterval {
+		return
+	}
+	lastProgress = time.Now()
+	fmt.Fprintf(os.Stderr, "\rFetched %s listens...", formatNumber(fetched))
+}
+
+// endProgress clears the progress line, if any.
+func endProgress() {
+	if lastProgress.IsZero() {
+		return
+	}
+	lastProgress = time.Time{}
+	fmt.Fprint(os.Stderr, "\r\033[K")
+}
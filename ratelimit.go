@@ -0,0 +1,139 @@
+// ratelimit.go: ListenBrainz rate limiting.
+
+package main
+
+import (
+	"math/rand"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// RateLimitLow is the number of remaining requests in the current rate
+// limit window below which requests are held until the window resets.
+const RateLimitLow = 2
+
+// RateLimit describes the X-RateLimit-* response headers.
+type RateLimit struct {
+	Limit     int
+	Remaining int
+	Reset     time.Time
+}
+
+// rateLimit parses the rate limit headers of a response. The second
+// result is false when the headers are missing.
+func rateLimit(resp *http.Response) (RateLimit, bool) {
+	var limit RateLimit
+	var err error
+
+	remaining := resp.Header.Get("X-RateLimit-Remaining")
+	if remaining == "" {
+		return limit, false
+	}
+	if limit.Remaining, err = strconv.Atoi(remaining); err != nil {
+		return limit, false
+	}
+
+	limit.Limit, _ = strconv.Atoi(resp.Header.Get("X-RateLimit-Limit"))
+
+	if in, err := strconv.Atoi(resp.Header.Get("X-RateLimit-Reset-In")); err == nil {
+		limit.Reset = time.Now().Add(time.Duration(in) * time.Second)
+	} else if at, err := strconv.ParseInt(resp.Header.Get("X-RateLimit-Reset"), 10, 64); err == nil {
+		limit.Reset = time.Unix(at, 0)
+	}
+
+	return limit, true
+}
+
+// random seeds the -jitter delays, guarded by randomMu for the users
+// fetched at once.
+var (
+	random   = rand.New(rand.NewSource(time.Now().UnixNano()))
+	randomMu sync.Mutex
+)
+
+// sleepJitter sleeps a random duration of up to -jitter, so that
+// concurrent runs do not request pages in lockstep.
+func sleepJitter() {
+	if jitter <= 0 {
+		return
+	}
+	randomMu.Lock()
+	delay := time.Duration(random.Int63n(int64(jitter)))
+	randomMu.Unlock()
+	debugf("jitter: waiting %s\n", delay.Round(time.Millisecond))
+	sleep(delay)
+}
+
+// throttle logs the rate limit of a response in verbose mode and sleeps
+// until the window resets when few requests remain.
+func throttle(resp *http.Response) {
+	limit, ok := rateLimit(resp)
+	if !ok {
+		return
+	}
+
+	debugf("rate limit: %d/%d remaining, resets at %s\n",
+		limit.Remaining, limit.Limit, limit.Reset.Format(time.RFC3339))
+
+	if limit.Remaining < RateLimitLow {
+		wait := time.Until(limit.Reset)
+		if wait > 0 {
+			debugf("rate limit low, waiting %s\n", wait.Round(time.Second))
+			sleep(wait)
+		}
+	}
+}
+
+// Pacer spreads requests evenly over the rate limit window, so that the
+// remaining requests last until it resets. Concurrent requests are given
+// successive slots.
+type Pacer struct {
+	mu       sync.Mutex
+	next     time.Time
+	interval time.Duration
+}
+
+// Observe schedules the next request from the rate limit of a response,
+// never earlier than the slots already given.
+func (p *Pacer) Observe(resp *http.Response) {
+	if p == nil {
+		return
+	}
+	limit, ok := rateLimit(resp)
+	if !ok || limit.Reset.IsZero() {
+		return
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	next := limit.Reset
+	p.interval = 0
+	if limit.Remaining > 0 {
+		p.interval = time.Until(limit.Reset) / time.Duration(limit.Remaining)
+		next = time.Now().Add(p.interval)
+	}
+	if next.After(p.next) {
+		p.next = next
+	}
+}
+
+// Wait sleeps until the next request is due, taking its slot.
+func (p *Pacer) Wait() {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	wait := time.Until(p.next)
+	if wait < 0 {
+		p.next = time.Now()
+	}
+	p.next = p.next.Add(p.interval)
+	p.mu.Unlock()
+
+	if wait > 0 {
+		debugf("pacing: waiting %s\n", wait.Round(time.Millisecond))
+		sleep(wait)
+	}
+}
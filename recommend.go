@@ -0,0 +1,82 @@
+// recommend.go: Recording recommendations.
+
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// Recommendation is a recording recommended to a user.
+type Recommendation struct {
+	Recording    string  `json:"recording_mbid"`
+	Score        float64 `json:"score"`
+	LastListened int64   `json:"latest_listened_at"`
+}
+
+// Recommendations is the response of the recording recommendation endpoint.
+type Recommendations struct {
+	Payload struct {
+		Count       int              `json:"count"`
+		Entity      string           `json:"entity"`
+		LastUpdated int64            `json:"last_updated"`
+		Offset      int              `json:"offset"`
+		Total       int              `json:"total_mbid_count"`
+		UserName    string           `json:"user_name"`
+		Mbids       []Recommendation `json:"mbids"`
+	} `json:"payload"`
+}
+
+// GetRecommendations returns the recordings recommended to user.
+func (c *Client) GetRecommendations(user string) (Recommendations, error) {
+	var recommendations Recommendations
+
+	url := fmt.Sprintf("%s/cf/recommendation/user/%s/recording?count=%d", c.API, user, ItemsPerPage)
+	req, err := http.NewRequestWithContext(runContext, "GET", url, nil)
+	if err != nil {
+		return recommendations, err
+	}
+
+	resp, err := c.do(req)
+	if err != nil {
+		return recommendations, err
+	}
+	defer resp.Body.Close()
+
+	// No recommendations were generated for the user yet.
+	if resp.StatusCode == http.StatusNoContent {
+		return recommendations, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return recommendations, statusError(resp)
+	}
+
+	body, err := readBody(resp)
+	if err != nil {
+		return recommendations, err
+	}
+
+	if err := decodeJSON(body, &recommendations); err != nil {
+		return recommendations, fmt.Errorf("%s: %w", resp.Status, err)
+	}
+
+	return recommendations, nil
+}
+
+// recommend prints the recordings recommended to the user.
+func recommend() {
+	recommendations, err := client.GetRecommendations(userName)
+	if err != nil {
+		fail("failed getting recommendations", err)
+	}
+
+	if recommendations.Payload.LastUpdated > 0 {
+		debugf("recommendations updated at %s\n",
+			time.Unix(recommendations.Payload.LastUpdated, 0).In(location).Format(time.RFC3339))
+	}
+
+	for _, recommendation := range recommendations.Payload.Mbids {
+		fmt.Fprintf(output, "<%s> %.3f\n", recommendation.Recording, recommendation.Score)
+	}
+}
@@ -0,0 +1,326 @@
+// report.go: Listening reports.
+
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// DateLayout formats the day of a listen in reports.
+const DateLayout = "2006-01-02"
+
+// day truncates t to midnight in the configured location.
+func day(t time.Time) time.Time {
+	t = t.In(location)
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, location)
+}
+
+// countByDay prints "date,count" CSV rows for every day of the -since-*
+// window, or from the oldest listen when there is none, until today,
+// including days without listens.
+func countByDay(listens []Listen) {
+	countDays(listens, cutOffTime, time.Now())
+}
+
+// countDays prints "date,count" CSV rows for every day from from, or from
+// the oldest listen when zero, to to, leaving out the days with fewer than
+// -min-per-day listens.
+func countDays(listens []Listen, from, to time.Time) {
+	w := csv.NewWriter(output)
+	defer w.Flush()
+
+	w.Write([]string{"date", "count"})
+	listens = timed(listens)
+	if len(listens) == 0 && from.IsZero() {
+		return
+	}
+
+	counts := make(map[string]int)
+	var first time.Time
+	for _, listen := range listens {
+		d := day(listen.Time())
+		counts[d.Format(DateLayout)]++
+		if first.IsZero() || d.Before(first) {
+			first = d
+		}
+	}
+	if !from.IsZero() {
+		first = day(from)
+	}
+
+	for d, last := first, day(to); !d.After(last); d = d.AddDate(0, 0, 1) {
+		date := d.Format(DateLayout)
+		if counts[date] < minPerDay {
+			continue
+		}
+		w.Write([]string{date, fmt.Sprint(counts[date])})
+	}
+}
+
+// printHeatmap prints CSV counts of the listens per day of the week,
+// from Monday, and hour of the day in the configured location.
+func printHeatmap(listens []Listen) {
+	var counts [7][24]int
+	for _, listen := range timed(listens) {
+		t := listen.Time().In(location)
+		counts[(t.Weekday()+6)%7][t.Hour()]++
+	}
+
+	w := csv.NewWriter(output)
+	defer w.Flush()
+
+	header := []string{"day"}
+	for hour := 0; hour < 24; hour++ {
+		header = append(header, fmt.Sprintf("%02d", hour))
+	}
+	w.Write(header)
+
+	for i, hours := range counts {
+		row := []string{time.Weekday((i + 1) % 7).String()[:3]}
+		for _, count := range hours {
+			row = append(row, fmt.Sprint(count))
+		}
+		w.Write(row)
+	}
+}
+
+// Stats profiles a set of listens.
+type Stats struct {
+	Listens  int
+	Artists  int
+	Tracks   int
+	Earliest time.Time
+	Latest   time.Time
+	PerDay   float64
+}
+
+func listenStats(listens []Listen) Stats {
+	var stats Stats
+	listens = timed(listens)
+	stats.Listens = len(listens)
+	if stats.Listens == 0 {
+		return stats
+	}
+
+	artists := make(map[string]bool)
+	tracks := make(map[Track]bool)
+	stats.Earliest, stats.Latest = listens[0].Time(), listens[0].Time()
+	for _, listen := range listens {
+		artists[groupKey(listen.Track.Artist)] = true
+		tracks[Track{Name: groupKey(listen.Track.Name), Artist: groupKey(listen.Track.Artist)}] = true
+		if listen.Time().Before(stats.Earliest) {
+			stats.Earliest = listen.Time()
+		}
+		if listen.Time().After(stats.Latest) {
+			stats.Latest = listen.Time()
+		}
+	}
+	stats.Artists = len(artists)
+	stats.Tracks = len(tracks)
+
+	days := 1
+	for d := day(stats.Earliest); d.Before(day(stats.Latest)); d = d.AddDate(0, 0, 1) {
+		days++
+	}
+	stats.PerDay = float64(stats.Listens) / float64(days)
+
+	return stats
+}
+
+func printStats(listens []Listen) {
+	stats := listenStats(listens)
+	fmt.Fprintf(output, "Total listens: %s\n", formatNumber(stats.Listens))
+	fmt.Fprintf(output, "Distinct artists: %s\n", formatNumber(stats.Artists))
+	fmt.Fprintf(output, "Distinct tracks: %s\n", formatNumber(stats.Tracks))
+	if stats.Listens > 0 {
+		fmt.Fprintf(output, "Earliest listen: %s\n", stats.Earliest.In(location).Format(DateLayout))
+		fmt.Fprintf(output, "Latest listen: %s\n", stats.Latest.In(location).Format(DateLayout))
+	}
+	fmt.Fprintf(output, "Listens per day: %.2f\n", stats.PerDay)
+}
+
+// BarWidth is the width of the longest bar of a report.
+const BarWidth = 40
+
+func bar(count, max int) string {
+	if max == 0 {
+		return ""
+	}
+	return strings.Repeat("#", (count*BarWidth+max-1)/max)
+}
+
+// printArtistComparison prints the listens of each -artist pattern, their
+// share and the ratio between the two.
+func printArtistComparison(listens []Listen) {
+	counts := make([]int, len(artistFilters))
+	filters := make([]*regexp.Regexp, len(artistFilters))
+	for i, pattern := range artistFilters {
+		filters[i] = compilePattern(pattern)
+	}
+	for _, listen := range listens {
+		if normalizeNFC {
+			listen = normalized(listen)
+		}
+		for i, re := range filters {
+			if re.MatchString(listen.Track.Artist) {
+				counts[i]++
+			}
+		}
+	}
+
+	total, max := 0, 0
+	for _, count := range counts {
+		total += count
+		if count > max {
+			max = count
+		}
+	}
+
+	w := tabwriter.NewWriter(output, 0, 0, 2, ' ', 0)
+	for i, pattern := range artistFilters {
+		share := 0.0
+		if total > 0 {
+			share = 100 * float64(counts[i]) / float64(total)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%.1f%%\t%s\n", pattern, formatNumber(counts[i]), share, bar(counts[i], max))
+	}
+	w.Flush()
+
+	if counts[1] > 0 {
+		fmt.Fprintf(output, "Ratio: %.2f\n", float64(counts[0])/float64(counts[1]))
+	} else {
+		fmt.Fprintln(output, "Ratio: n/a")
+	}
+}
+
+// ageBuckets are the listen age ranges of -age-report, from the start of
+// each calendar period in the configured location.
+var ageBuckets = []string{"Today", "This week", "This month", "This year", "Older"}
+
+// ageBucket returns the index of the age range of t in ageBuckets, weeks
+// starting on Monday.
+func ageBucket(t, now time.Time) int {
+	today := day(now)
+	weekday := (int(today.Weekday()) + 6) % 7
+	starts := []time.Time{
+		today,
+		today.AddDate(0, 0, -weekday),
+		time.Date(today.Year(), today.Month(), 1, 0, 0, 0, 0, location),
+		time.Date(today.Year(), time.January, 1, 0, 0, 0, 0, location),
+	}
+	for i, start := range starts {
+		if !t.Before(start) {
+			return i
+		}
+	}
+	return len(ageBuckets) - 1
+}
+
+// printAgeReport prints the number of listens per age range.
+func printAgeReport(listens []Listen) {
+	counts := make([]int, len(ageBuckets))
+	now := time.Now()
+	for _, listen := range timed(listens) {
+		counts[ageBucket(listen.Time(), now)]++
+	}
+
+	w := tabwriter.NewWriter(output, 0, 0, 2, ' ', 0)
+	for i, bucket := range ageBuckets {
+		fmt.Fprintf(w, "%s\t%s\n", bucket, formatNumber(counts[i]))
+	}
+	w.Flush()
+}
+
+// Gap is a span of time without listens.
+type Gap struct {
+	From time.Time
+	To   time.Time
+}
+
+// findGaps returns the spans longer than min without listens, oldest
+// first, from from and until to when not zero.
+func findGaps(listens []Listen, from, to time.Time, min time.Duration) []Gap {
+	var times []time.Time
+	if !from.IsZero() {
+		times = append(times, from)
+	}
+	for _, listen := range timed(listens) {
+		times = append(times, listen.Time())
+	}
+	sort.Slice(times, func(i, j int) bool { return times[i].Before(times[j]) })
+	if !to.IsZero() {
+		times = append(times, to)
+	}
+
+	var gaps []Gap
+	for i := 1; i < len(times); i++ {
+		if times[i].Sub(times[i-1]) > min {
+			gaps = append(gaps, Gap{times[i-1], times[i]})
+		}
+	}
+	return gaps
+}
+
+// formatSpan renders a duration in days and hours, or hours and minutes
+// under a day, e.g. "3d 4h".
+func formatSpan(d time.Duration) string {
+	if d < 24*time.Hour {
+		return fmt.Sprintf("%dh %dm", int(d/time.Hour), int(d%time.Hour/time.Minute))
+	}
+	return fmt.Sprintf("%dd %dh", int(d/(24*time.Hour)), int(d%(24*time.Hour)/time.Hour))
+}
+
+// printGaps prints the spans longer than -gap-threshold without listens
+// over the -since-* window, or from the oldest listen, until now.
+func printGaps(listens []Listen) {
+	w := tabwriter.NewWriter(output, 0, 0, 2, ' ', 0)
+	for _, gap := range findGaps(listens, cutOffTime, time.Now(), gapMin) {
+		fmt.Fprintf(w, "%s\t%s\t%s\n", gap.From.In(location).Format(time.RFC3339),
+			gap.To.In(location).Format(time.RFC3339), formatSpan(gap.To.Sub(gap.From)))
+	}
+	w.Flush()
+}
+
+// RunSummary is the summary of a run written by -summary-json.
+type RunSummary struct {
+	Counts
+	DurationSeconds float64 `json:"duration_seconds"`
+}
+
+// writeSummary writes the summary of a run started at start as JSON to
+// -summary-file, or to stderr with -summary-json, prefixed with
+// "(summary)" when -log-stdout mixes it with the data output. Nothing is
+// written for runs without matches with -summary-only-on-change.
+func writeSummary(result *RunResult, start time.Time) {
+	if (!summaryJSON && summaryFile == "") || (quietNoop && result.Matched.Load() == 0) {
+		return
+	}
+
+	data, err := json.Marshal(RunSummary{result.Counts(), time.Since(start).Seconds()})
+	if err != nil {
+		fmt.Println("Error: failed encoding summary:", err)
+		exit(1)
+	}
+	data = append(data, '\n')
+
+	if summaryFile != "" {
+		err = os.WriteFile(summaryFile, data, 0o644)
+	} else {
+		if logOutput == os.Stdout {
+			data = append([]byte("(summary) "), data...)
+		}
+		_, err = logOutput.Write(data)
+	}
+	if err != nil {
+		fmt.Println("Error: failed writing summary:", err)
+		exit(1)
+	}
+}
@@ -0,0 +1,53 @@
+// result.go: Accounting of runs.
+
+package main
+
+import (
+	"encoding/json"
+	"sync/atomic"
+)
+
+// RunResult counts the outcome of a run. Its counters are safe for
+// concurrent use.
+type RunResult struct {
+	Fetched atomic.Int64
+	Matched atomic.Int64
+	Deleted atomic.Int64
+	Failed  atomic.Int64
+	Skipped atomic.Int64
+}
+
+// Counts is a snapshot of the counters of a RunResult.
+type Counts struct {
+	Fetched int64 `json:"fetched"`
+	Matched int64 `json:"matched"`
+	Deleted int64 `json:"deleted"`
+	Failed  int64 `json:"failed"`
+	Skipped int64 `json:"skipped"`
+}
+
+// Counts returns the current counters.
+func (r *RunResult) Counts() Counts {
+	return Counts{
+		Fetched: r.Fetched.Load(),
+		Matched: r.Matched.Load(),
+		Deleted: r.Deleted.Load(),
+		Failed:  r.Failed.Load(),
+		Skipped: r.Skipped.Load(),
+	}
+}
+
+// Add adds the counters of other to r.
+func (r *RunResult) Add(other *RunResult) {
+	counts := other.Counts()
+	r.Fetched.Add(counts.Fetched)
+	r.Matched.Add(counts.Matched)
+	r.Deleted.Add(counts.Deleted)
+	r.Failed.Add(counts.Failed)
+	r.Skipped.Add(counts.Skipped)
+}
+
+// MarshalJSON encodes the counters as a JSON object of numbers.
+func (r *RunResult) MarshalJSON() ([]byte, error) {
+	return json.Marshal(r.Counts())
+}
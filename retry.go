@@ -0,0 +1,84 @@
+// retry.go: Retries of failed requests.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// MaxRetryDelay caps the exponential backoff between retries.
+const MaxRetryDelay = 30 * time.Second
+
+// RetryBudget bounds the total time spent waiting to retry requests over
+// a whole run, rather than per request.
+type RetryBudget struct {
+	mu        sync.Mutex
+	remaining time.Duration
+}
+
+// NewRetryBudget returns a RetryBudget of d.
+func NewRetryBudget(d time.Duration) *RetryBudget {
+	return &RetryBudget{remaining: d}
+}
+
+// Spend draws d from the budget, reporting whether it was available.
+func (b *RetryBudget) Spend(d time.Duration) bool {
+	if b == nil {
+		return false
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if d > b.remaining {
+		return false
+	}
+	b.remaining -= d
+	return true
+}
+
+// Remaining returns the time left in the budget.
+func (b *RetryBudget) Remaining() time.Duration {
+	if b == nil {
+		return 0
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.remaining
+}
+
+// retryable reports whether a request failing with resp or err should
+// be retried: on network errors, rate limiting and server errors. Requests
+// whose context is done cannot be retried.
+func retryable(resp *http.Response, err error) bool {
+	if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, context.Canceled) {
+		return false
+	}
+	if err != nil {
+		return true
+	}
+	return resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500
+}
+
+// retryDelay returns the time to wait before retrying, from Retry-After
+// or the rate limit reset when given, else backing off exponentially.
+func retryDelay(resp *http.Response, attempt int) time.Duration {
+	if resp != nil {
+		if secs, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil {
+			return time.Duration(secs) * time.Second
+		}
+		if limit, ok := rateLimit(resp); ok && resp.StatusCode == http.StatusTooManyRequests {
+			if wait := time.Until(limit.Reset); wait > 0 {
+				return wait
+			}
+		}
+	}
+	delay := time.Second << attempt
+	if delay > MaxRetryDelay || delay <= 0 {
+		delay = MaxRetryDelay
+	}
+	return delay
+}
@@ -0,0 +1,59 @@
+// selftest.go: Connectivity and authentication checks.
+
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+// check prints the outcome of a self-test step and reports whether it
+// passed.
+func check(name string, err error) bool {
+	if err != nil {
+		fmt.Printf("FAIL %s: %s\n", name, err)
+		return false
+	}
+	fmt.Printf("PASS %s\n", name)
+	return true
+}
+
+// selfTest checks the token, the API, the user and a page fetch, exiting
+// with an error when any fails. Later steps are skipped when the ones
+// they depend on fail.
+func selfTest() {
+	ok := true
+	defer func() {
+		if !ok {
+			exit(1)
+		}
+	}()
+
+	if os.Getenv(tokenEnv) == "" {
+		ok = check("token is set", fmt.Errorf("please define %s", tokenEnv))
+		return
+	}
+	check("token is set", nil)
+
+	validation, err := client.ValidateToken()
+	if ok = check("API is reachable", err); !ok {
+		return
+	}
+	if !validation.Valid {
+		err = fmt.Errorf("%s", validation.Message)
+	}
+	ok = check("token is valid for user "+validation.UserName, err) && ok
+
+	if userName == "" {
+		fmt.Println("SKIP user exists: -u is missing")
+		fmt.Println("SKIP listens can be fetched: -u is missing")
+		return
+	}
+
+	_, err = client.GetListenCount(userName)
+	ok = check("user "+userName+" exists", err) && ok
+
+	url := fmt.Sprintf("%s/user/%s/listens?count=1", client.API, userName)
+	_, err = client.fetchListens(url)
+	ok = check("listens can be fetched", err) && ok
+}
@@ -0,0 +1,265 @@
+// state.go: Snapshots of fetched listens.
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+var (
+	// loadedListens are served instead of fetching with -load-state.
+	loadedListens []Listen
+	// fetchedListens records the fetched listens for -dump-state.
+	fetchedListens []Listen
+)
+
+// readState reads a snapshot of listens, sorted newest-first.
+func readState(path string) ([]Listen, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var listens []Listen
+	if err := json.Unmarshal(data, &listens); err != nil {
+		return nil, err
+	}
+
+	sort.SliceStable(listens, func(i, j int) bool {
+		return listens[i].ListenedAt > listens[j].ListenedAt
+	})
+
+	return listens, nil
+}
+
+// AnonymousUser replaces the user name of exported listens with -anonymize.
+const AnonymousUser = "anonymous"
+
+// anonymized returns listen with its user name replaced and, with
+// -hash-msid, its recording_msid replaced by a UUID derived from its
+// SHA-256, so that the listens of a recording still share one.
+func anonymized(listen Listen) Listen {
+	if listen.UserName != "" {
+		listen.UserName = AnonymousUser
+	}
+	if hashMSID && listen.Recording != "" {
+		sum := sha256.Sum256([]byte(listen.Recording))
+		listen.Recording = fmt.Sprintf("%x-%x-%x-%x-%x", sum[0:4], sum[4:6], sum[6:8], sum[8:10], sum[10:16])
+	}
+	return listen
+}
+
+// writeState writes a snapshot of listens, without duplicates, returning
+// the number of listens written. Listens are anonymized with -anonymize.
+func writeState(path string, listens []Listen) (int, error) {
+	var unique []Listen
+	seen := make(map[listenKey]bool)
+	for _, listen := range listens {
+		if !seen[listen.key()] {
+			seen[listen.key()] = true
+			if anonymize {
+				listen = anonymized(listen)
+			}
+			unique = append(unique, listen)
+		}
+	}
+
+	data, err := json.MarshalIndent(unique, "", "  ")
+	if err != nil {
+		return 0, err
+	}
+
+	return len(unique), os.WriteFile(path, append(data, '\n'), 0o644)
+}
+
+// stateListens returns the listens, newest first, older than max, or all
+// of them when max is zero, as a single page.
+func stateListens(listens []Listen, max int64) Listens {
+	var page Listens
+	for _, listen := range listens {
+		if max == 0 || listen.ListenedAt < max {
+			page.Payload.Listens = append(page.Payload.Listens, listen)
+		}
+	}
+	page.Payload.Count = len(page.Payload.Listens)
+	return page
+}
+
+// readListensFile reads listens from JSON lines, each with at least the
+// listened_at and recording_msid of a listen.
+func readListensFile(path string) ([]Listen, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var listens []Listen
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		var listen Listen
+		if err := json.Unmarshal(line, &listen); err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, n, err)
+		}
+		if listen.ListenedAt == 0 || listen.Recording == "" {
+			return nil, fmt.Errorf("%s:%d: listened_at or recording_msid missing", path, n)
+		}
+		listens = append(listens, listen)
+	}
+
+	return listens, scanner.Err()
+}
+
+// diffStates prints the listens added to and removed from a snapshot,
+// newest first, compared by listened_at and recording_msid.
+func diffStates(before, after string) error {
+	old, err := readState(before)
+	if err != nil {
+		return err
+	}
+	cur, err := readState(after)
+	if err != nil {
+		return err
+	}
+
+	oldKeys := make(map[listenKey]bool)
+	for _, listen := range old {
+		oldKeys[listen.key()] = true
+	}
+	curKeys := make(map[listenKey]bool)
+	for _, listen := range cur {
+		curKeys[listen.key()] = true
+	}
+
+	type change struct {
+		sign   string
+		listen Listen
+	}
+	var changes []change
+	for _, listen := range cur {
+		if !oldKeys[listen.key()] {
+			changes = append(changes, change{"+", listen})
+		}
+	}
+	for _, listen := range old {
+		if !curKeys[listen.key()] {
+			changes = append(changes, change{"-", listen})
+		}
+	}
+	sort.SliceStable(changes, func(i, j int) bool {
+		return changes[i].listen.ListenedAt > changes[j].listen.ListenedAt
+	})
+
+	for _, c := range changes {
+		fmt.Fprintf(output, "%s %s %s\n", c.sign,
+			formatTime(c.listen), c.listen)
+	}
+	return nil
+}
+
+// mergeStates writes the listens of several snapshots into one, newest
+// first and without duplicates.
+func mergeStates(path string, inputs []string) (int, error) {
+	var listens []Listen
+	for _, input := range inputs {
+		state, err := readState(input)
+		if err != nil {
+			return 0, fmt.Errorf("%s: %w", input, err)
+		}
+		listens = append(listens, state...)
+	}
+
+	sort.SliceStable(listens, func(i, j int) bool {
+		return listens[i].ListenedAt > listens[j].ListenedAt
+	})
+
+	return writeState(path, listens)
+}
+
+// parseFile prints the listens of a page of the listens API saved in a
+// file, decoded like fetched pages, to check how it parses.
+func parseFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	var page Listens
+	if err := decodeJSON(data, &page); err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+
+	for _, listen := range page.Payload.Listens {
+		fmt.Fprintln(output, formatListen(listen))
+	}
+	fmt.Printf("Parsed %s listens, count %s.\n", formatNumber(page.length()), formatNumber(page.Payload.Count))
+	return nil
+}
+
+// checksum returns the SHA-256, in hex, of the distinct listened_at and
+// recording_msid pairs of the listens, sorted, so that it only changes
+// with the set of listens and not with their order.
+func checksum(listens []Listen) string {
+	keys := make([]listenKey, 0, len(listens))
+	seen := make(map[listenKey]bool)
+	for _, listen := range listens {
+		if !seen[listen.key()] {
+			seen[listen.key()] = true
+			keys = append(keys, listen.key())
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].ListenedAt != keys[j].ListenedAt {
+			return keys[i].ListenedAt < keys[j].ListenedAt
+		}
+		return keys[i].Recording < keys[j].Recording
+	})
+
+	hash := sha256.New()
+	for _, key := range keys {
+		fmt.Fprintf(hash, "%d\t%s\n", key.ListenedAt, key.Recording)
+	}
+	return fmt.Sprintf("%x", hash.Sum(nil))
+}
+
+// readSinceFile reads the Unix timestamp stored in a -since-file, or zero
+// when the file does not exist.
+func readSinceFile(path string) (int64, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, nil
+	} else if err != nil {
+		return 0, err
+	}
+	ts, err := strconv.ParseInt(strings.TrimSpace(string(data)), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("%s: invalid timestamp: %w", path, err)
+	}
+	return ts, nil
+}
+
+// updateSinceFile stores the time of the newest listen fetched in
+// -since-file with -update-since-file, leaving it as is when no newer
+// listen was fetched.
+func updateSinceFile() {
+	if !updateSince || newestFetched == 0 {
+		return
+	}
+	if err := os.WriteFile(sinceFile, []byte(fmt.Sprintln(newestFetched)), 0o644); err != nil {
+		fmt.Println("Error: failed updating -since-file:", err)
+		exit(1)
+	}
+}
@@ -0,0 +1,35 @@
+// stream.go: Listing listens as pages arrive.
+
+package main
+
+import "fmt"
+
+// streaming reports whether -json-array-stream can write the matched
+// listens as the pages arrive: when neither deleting, a report, a source
+// other than paging, nor -keep-last, -sample, -dump-state or
+// -summary-only-on-change needs all of them first.
+func streaming() bool {
+	return jsonStream && !deleteListens && !reporting() && deleteFile == "" && deleteBefore == "" &&
+		listenAt == "" && deleteOldest <= 0 && keepLast <= 0 && sampleSize <= 0 && dumpState == "" && !quietNoop
+}
+
+// streamListens writes the matched listens as a JSON array as the pages
+// arrive, so that consumers get them before the whole history is fetched.
+func streamListens(result *RunResult) {
+	defer startPager()()
+
+	w, _ := listWriter()
+	array := &jsonArray{w: w}
+	eachListen(getListens, newMatcher(), func(listen Listen) bool {
+		if downloadCover {
+			listen.CoverArt = coverOf(listen)
+		}
+		endProgress()
+		if err := array.Add(listen); err != nil {
+			fmt.Printf("Warning: failed encoding listen: %s: %s\n", listen, err)
+		}
+		matched := result.Matched.Add(1)
+		return matchLimit <= 0 || matched < matchLimit
+	})
+	array.Close()
+}
@@ -0,0 +1,110 @@
+// submit.go: Listen submission.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+// MaxListensPerSubmission is the maximum number of listens the API
+// accepts in a single submission.
+const MaxListensPerSubmission = 1000
+
+// Listen types of submissions.
+const (
+	ListenTypeSingle     = "single"
+	ListenTypeImport     = "import"
+	ListenTypePlayingNow = "playing_now"
+)
+
+// submittedListen is a listen of a submission, without listened_at for
+// playing_now submissions.
+type submittedListen struct {
+	ListenedAt int64 `json:"listened_at,omitempty"`
+	Track      Track `json:"track_metadata"`
+}
+
+// submission is the payload of the submit-listens endpoint.
+type submission struct {
+	ListenType string            `json:"listen_type"`
+	Payload    []submittedListen `json:"payload"`
+}
+
+// SubmitListens submits listens of the token's user with a listen type.
+func (c *Client) SubmitListens(listenType string, listens []Listen) error {
+	payload := submission{ListenType: listenType}
+	for _, listen := range listens {
+		submitted := submittedListen{Track: listen.Track}
+		submitted.Track.Mapping = nil
+		if listenType != ListenTypePlayingNow {
+			submitted.ListenedAt = listen.ListenedAt
+		}
+		payload.Payload = append(payload.Payload, submitted)
+	}
+
+	jsonpayload, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(runContext, "POST", c.API+"/submit-listens", bytes.NewBuffer(jsonpayload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	debugf("submitListens(%s, %d): response status: %s\n",
+		listenType, len(listens), resp.Status)
+
+	if resp.StatusCode != http.StatusOK {
+		return statusError(resp)
+	}
+	return nil
+}
+
+// submitPlayingNow submits the -artist and -track playing now.
+func submitPlayingNow() {
+	listen := Listen{Track: Track{Artist: artistFilters[0], Name: trackPattern, Release: albumPattern}}
+	if err := client.SubmitListens(ListenTypePlayingNow, []Listen{listen}); err != nil {
+		fail("failed submitting playing now", err)
+	}
+	fmt.Printf("Playing now: %s - \"%s\"\n", listen.Track.Artist, listen.Track.Name)
+}
+
+// replay re-submits the listens of a snapshot, such as one dumped before
+// deleting them, with their original times.
+func replay(path string) {
+	listens, err := readState(path)
+	if err != nil {
+		fmt.Println("Error: failed reading listens:", err)
+		exit(1)
+	}
+	listens = timed(listens)
+
+	restored := 0
+	for start := 0; start < len(listens); start += MaxListensPerSubmission {
+		end := start + MaxListensPerSubmission
+		if end > len(listens) {
+			end = len(listens)
+		}
+		if err := client.SubmitListens(ListenTypeImport, listens[start:end]); err != nil {
+			fmt.Printf("Warning: failed submitting %s listens: %s\n", formatNumber(end-start), err)
+			continue
+		}
+		restored += end - start
+	}
+
+	fmt.Printf("Restored %s of %s listens.\n", formatNumber(restored), formatNumber(len(listens)))
+	if restored < len(listens) {
+		exit(1)
+	}
+}
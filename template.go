@@ -0,0 +1,38 @@
+// template.go: Reports from text templates.
+
+package main
+
+import (
+	"text/template"
+)
+
+// TemplateData is the context of a -template-file.
+type TemplateData struct {
+	User    string
+	Listens []Listen
+	Stats   Stats
+}
+
+// executeTemplate executes the template file over the matched listens,
+// anonymized with -anonymize.
+func executeTemplate(path string, listens []Listen) error {
+	tmpl, err := template.ParseFiles(path)
+	if err != nil {
+		return err
+	}
+
+	user := userName
+	if anonymize {
+		user = AnonymousUser
+		anonymous := make([]Listen, len(listens))
+		for i, listen := range listens {
+			anonymous[i] = anonymized(listen)
+		}
+		listens = anonymous
+	}
+	return tmpl.Execute(output, TemplateData{
+		User:    user,
+		Listens: listens,
+		Stats:   listenStats(listens),
+	})
+}
@@ -0,0 +1,16 @@
+// term.go: Terminal detection.
+
+package main
+
+import (
+	"os"
+)
+
+// isTTY reports whether f is a terminal.
+func isTTY(f *os.File) bool {
+	info, err := f.Stat()
+	if err != nil {
+		return false
+	}
+	return info.Mode()&os.ModeCharDevice != 0
+}
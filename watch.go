@@ -0,0 +1,49 @@
+// watch.go: Live tail of listens.
+
+package main
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+// watchListens polls for listens newer than the matched ones and for the
+// listen playing now, printing the matching ones until interrupted.
+func watchListens(listens []Listen) {
+	latest := time.Now().Unix()
+	if len(listens) > 0 {
+		latest = listens[0].ListenedAt
+	}
+
+	match := newMatcher()
+	var playing Track
+	for {
+		sleep(time.Duration(watchInterval) * time.Second)
+
+		now := client.GetPlayingNow(userName)
+		if now.length() > 0 {
+			listen := now.Payload.Listens[0]
+			if listen.Track.Name != playing.Name || listen.Track.Artist != playing.Artist {
+				playing = listen.Track
+				if match(listen) {
+					fmt.Fprintf(output, "Now playing: %s - \"%s\"\n", playing.Artist, playing.Name)
+				}
+			}
+		}
+
+		page := client.GetListensAfter(userName, latest)
+		sort.Slice(page.Payload.Listens, func(i, j int) bool {
+			return page.Payload.Listens[i].ListenedAt < page.Payload.Listens[j].ListenedAt
+		})
+		for _, listen := range page.Payload.Listens {
+			if listen.ListenedAt <= latest {
+				continue
+			}
+			latest = listen.ListenedAt
+			if match(listen) {
+				fmt.Fprintln(output, formatListen(listen))
+			}
+		}
+	}
+}
@@ -0,0 +1,272 @@
+// where.go: The -where filter expressions.
+//
+//	expr       = and { "||" and }
+//	and        = unary { "&&" unary }
+//	unary      = "!" unary | "(" expr ")" | comparison
+//	comparison = field op literal
+//
+// String fields compare with quoted strings by ==, != and the regexp
+// operators =~ and !~, matching case-insensitively. Number fields,
+// derived from the time of a listen in the configured location, compare
+// with integers by ==, !=, <, <=, > and >=.
+
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// whereStrings are the string fields of -where expressions.
+var whereStrings = map[string]func(Listen) string{
+	"artist": func(listen Listen) string { return listen.Track.Artist },
+	"track":  func(listen Listen) string { return listen.Track.Name },
+	"album":  func(listen Listen) string { return listen.Track.Release },
+	"msid":   func(listen Listen) string { return listen.Recording },
+	"source": func(listen Listen) string { return strings.Join(listen.Track.Info.Sources(), " ") },
+}
+
+// whereNumbers are the number fields of -where expressions. The weekday
+// counts from 1 for Monday to 7 for Sunday.
+var whereNumbers = map[string]func(Listen) int64{
+	"ts":      func(listen Listen) int64 { return listen.ListenedAt },
+	"year":    func(listen Listen) int64 { return int64(listen.Time().In(location).Year()) },
+	"month":   func(listen Listen) int64 { return int64(listen.Time().In(location).Month()) },
+	"day":     func(listen Listen) int64 { return int64(listen.Time().In(location).Day()) },
+	"hour":    func(listen Listen) int64 { return int64(listen.Time().In(location).Hour()) },
+	"minute":  func(listen Listen) int64 { return int64(listen.Time().In(location).Minute()) },
+	"weekday": func(listen Listen) int64 { return int64((listen.Time().In(location).Weekday()+6)%7 + 1) },
+}
+
+// WhereFields lists the fields of -where expressions.
+var WhereFields = []string{"artist", "track", "album", "msid", "source",
+	"ts", "year", "month", "day", "hour", "minute", "weekday"}
+
+// whereOperators are the operators of -where expressions, longest first.
+var whereOperators = []string{"&&", "||", "=~", "!~", "==", "!=", "<=", ">=", "<", ">", "!", "(", ")"}
+
+// token is a lexical token of a -where expression.
+type token struct {
+	pos  int
+	kind byte // 'i'dentifier, 's'tring, 'n'umber or 'o'perator
+	text string
+}
+
+// lexWhere splits a -where expression into tokens.
+func lexWhere(s string) ([]token, error) {
+	var tokens []token
+	for i := 0; i < len(s); {
+		r := rune(s[i])
+		switch {
+		case unicode.IsSpace(r):
+			i++
+		case r == '"':
+			j := i + 1
+			for ; j < len(s) && s[j] != '"'; j++ {
+				if s[j] == '\\' {
+					j++
+				}
+			}
+			if j >= len(s) {
+				return nil, fmt.Errorf("unterminated string at %d", i+1)
+			}
+			text, err := strconv.Unquote(s[i : j+1])
+			if err != nil {
+				return nil, fmt.Errorf("invalid string at %d", i+1)
+			}
+			tokens = append(tokens, token{i, 's', text})
+			i = j + 1
+		case unicode.IsDigit(r) || r == '-':
+			j := i + 1
+			for j < len(s) && unicode.IsDigit(rune(s[j])) {
+				j++
+			}
+			tokens = append(tokens, token{i, 'n', s[i:j]})
+			i = j
+		case unicode.IsLetter(r):
+			j := i + 1
+			for j < len(s) && (unicode.IsLetter(rune(s[j])) || s[j] == '_') {
+				j++
+			}
+			tokens = append(tokens, token{i, 'i', s[i:j]})
+			i = j
+		default:
+			op := ""
+			for _, o := range whereOperators {
+				if strings.HasPrefix(s[i:], o) {
+					op = o
+					break
+				}
+			}
+			if op == "" {
+				return nil, fmt.Errorf("unexpected %q at %d", s[i], i+1)
+			}
+			tokens = append(tokens, token{i, 'o', op})
+			i += len(op)
+		}
+	}
+	return tokens, nil
+}
+
+// whereParser compiles tokens into a Listen predicate.
+type whereParser struct {
+	tokens []token
+	next   int
+}
+
+func (p *whereParser) peek() token {
+	if p.next < len(p.tokens) {
+		return p.tokens[p.next]
+	}
+	return token{}
+}
+
+func (p *whereParser) accept(op string) bool {
+	if t := p.peek(); t.kind == 'o' && t.text == op {
+		p.next++
+		return true
+	}
+	return false
+}
+
+func (p *whereParser) errorf(format string, args ...interface{}) error {
+	if p.next >= len(p.tokens) {
+		return fmt.Errorf("at end: "+format, args...)
+	}
+	return fmt.Errorf("at %d: "+format, append([]interface{}{p.tokens[p.next].pos + 1}, args...)...)
+}
+
+func (p *whereParser) expr() (func(Listen) bool, error) {
+	left, err := p.and()
+	for err == nil && p.accept("||") {
+		var right func(Listen) bool
+		if right, err = p.and(); err == nil {
+			l := left
+			left = func(listen Listen) bool { return l(listen) || right(listen) }
+		}
+	}
+	return left, err
+}
+
+func (p *whereParser) and() (func(Listen) bool, error) {
+	left, err := p.unary()
+	for err == nil && p.accept("&&") {
+		var right func(Listen) bool
+		if right, err = p.unary(); err == nil {
+			l := left
+			left = func(listen Listen) bool { return l(listen) && right(listen) }
+		}
+	}
+	return left, err
+}
+
+func (p *whereParser) unary() (func(Listen) bool, error) {
+	if p.accept("!") {
+		operand, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		return func(listen Listen) bool { return !operand(listen) }, nil
+	}
+	if p.accept("(") {
+		inner, err := p.expr()
+		if err != nil {
+			return nil, err
+		}
+		if !p.accept(")") {
+			return nil, p.errorf("expected )")
+		}
+		return inner, nil
+	}
+	return p.comparison()
+}
+
+func (p *whereParser) comparison() (func(Listen) bool, error) {
+	field := p.peek()
+	if field.kind != 'i' {
+		return nil, p.errorf("expected a field: %s", strings.Join(WhereFields, ", "))
+	}
+	p.next++
+
+	op := p.peek()
+	if op.kind != 'o' {
+		return nil, p.errorf("expected an operator")
+	}
+	p.next++
+
+	value := p.peek()
+	if str, ok := whereStrings[field.text]; ok {
+		if value.kind != 's' {
+			return nil, p.errorf("expected a string to compare %s with", field.text)
+		}
+		p.next++
+		text := value.text
+		if normalizeNFC {
+			text = norm.NFC.String(text)
+		}
+		switch op.text {
+		case "==":
+			return func(listen Listen) bool { return str(listen) == text }, nil
+		case "!=":
+			return func(listen Listen) bool { return str(listen) != text }, nil
+		case "=~", "!~":
+			re, err := compileRegexp(value.text)
+			if err != nil {
+				return nil, fmt.Errorf("at %d: %w", value.pos+1, err)
+			}
+			want := op.text == "=~"
+			return func(listen Listen) bool { return re.MatchString(str(listen)) == want }, nil
+		}
+		return nil, fmt.Errorf("at %d: invalid operator %s for %s", op.pos+1, op.text, field.text)
+	}
+
+	num, ok := whereNumbers[field.text]
+	if !ok {
+		return nil, fmt.Errorf("at %d: unknown field %s, expected: %s",
+			field.pos+1, field.text, strings.Join(WhereFields, ", "))
+	}
+	if value.kind != 'n' {
+		return nil, p.errorf("expected a number to compare %s with", field.text)
+	}
+	n, err := strconv.ParseInt(value.text, 10, 64)
+	if err != nil {
+		return nil, p.errorf("invalid number %s", value.text)
+	}
+	p.next++
+	switch op.text {
+	case "==":
+		return func(listen Listen) bool { return num(listen) == n }, nil
+	case "!=":
+		return func(listen Listen) bool { return num(listen) != n }, nil
+	case "<":
+		return func(listen Listen) bool { return num(listen) < n }, nil
+	case "<=":
+		return func(listen Listen) bool { return num(listen) <= n }, nil
+	case ">":
+		return func(listen Listen) bool { return num(listen) > n }, nil
+	case ">=":
+		return func(listen Listen) bool { return num(listen) >= n }, nil
+	}
+	return nil, fmt.Errorf("at %d: invalid operator %s for %s", op.pos+1, op.text, field.text)
+}
+
+// compileWhere compiles a -where expression into a Listen predicate.
+func compileWhere(s string) (func(Listen) bool, error) {
+	tokens, err := lexWhere(s)
+	if err != nil {
+		return nil, err
+	}
+	p := &whereParser{tokens: tokens}
+	match, err := p.expr()
+	if err != nil {
+		return nil, err
+	}
+	if p.next < len(tokens) {
+		return nil, p.errorf("unexpected %s", tokens[p.next].text)
+	}
+	return match, nil
+}
@@ -0,0 +1,74 @@
+// where_test.go: Tests of the -where filter expressions.
+
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestCompileWhere(t *testing.T) {
+	defer func(loc *time.Location) { location = loc }(location)
+	location = time.UTC
+
+	listens := []Listen{
+		// 2023-11-14 22:13 UTC
+		{Recording: "a", Track: Track{Artist: "The Beatles", Name: "Help!"}, ListenedAt: 1700000000},
+		// 2023-06-11 10:00 UTC
+		{Recording: "b", Track: Track{Artist: "The Beatles", Name: "Yesterday"}, ListenedAt: 1686477600},
+		// 2023-11-14 21:00 UTC
+		{Recording: "c", Track: Track{Artist: "Blur", Name: "Song 2"}, ListenedAt: 1699995600},
+	}
+
+	tests := []struct {
+		expr string
+		want []string
+	}{
+		{`artist =~ "beatles" && year == 2023 && hour >= 20`, []string{"a"}},
+		{`!(artist =~ "beatles")`, []string{"c"}},
+		{`! artist =~ "beatles" || track == "Yesterday"`, []string{"b", "c"}},
+		{`artist == "Blur" || artist =~ "beatles" && hour < 20`, []string{"b", "c"}},
+		{`(artist == "Blur" || artist =~ "beatles") && hour < 20`, []string{"b"}},
+		{`!!(month != 6)`, []string{"a", "c"}},
+	}
+	for _, test := range tests {
+		match, err := compileWhere(test.expr)
+		if err != nil {
+			t.Errorf("%s: %v", test.expr, err)
+			continue
+		}
+		var got []string
+		for _, listen := range listens {
+			if match(listen) {
+				got = append(got, listen.Recording)
+			}
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: got %v, want %v", test.expr, got, test.want)
+		}
+	}
+}
+
+func TestCompileWhereErrors(t *testing.T) {
+	tests := []struct {
+		expr string
+		want string
+	}{
+		{`artist == 2023`, "at 11: expected a string to compare artist with"},
+		{`year == "2023"`, "at 9: expected a number to compare year with"},
+		{`artist =~ "beatles`, "unterminated string at 11"},
+		{`(year == 2023`, "at end: expected )"},
+		{`year == 2023 hour`, "at 14: unexpected hour"},
+		{`year >= 2023 && `, "at end: expected a field: artist, track, album, msid, source, ts, year, month, day, hour, minute, weekday"},
+		{`artist < "b"`, "at 8: invalid operator < for artist"},
+	}
+	for _, test := range tests {
+		_, err := compileWhere(test.expr)
+		if err == nil {
+			t.Errorf("%s: got no error, want %q", test.expr, test.want)
+		} else if err.Error() != test.want {
+			t.Errorf("%s: got error %q, want %q", test.expr, err, test.want)
+		}
+	}
+}